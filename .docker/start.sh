@@ -3,5 +3,9 @@ set -e
 
 cd /root/
 
-aria2c --conf-path=/conf/aria2.conf
+if [ -n "$ARIA2SECRET" ]; then
+  aria2c --conf-path=/conf/aria2.conf --rpc-secret="$ARIA2SECRET"
+else
+  aria2c --conf-path=/conf/aria2.conf
+fi
 ./arias -config /conf/arias.toml
\ No newline at end of file
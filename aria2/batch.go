@@ -0,0 +1,145 @@
+package aria2
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Fault is the error returned by aria2 for a single call of a batch.
+type Fault struct {
+	Code    int
+	Message string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("aria2 error %d: %s", f.Code, f.Message)
+}
+
+// BatchCall is a call queued in a Batch.
+type BatchCall struct {
+	Method string
+	Params []interface{}
+	// Reply is filled with the result of the call once the batch was sent
+	Reply interface{}
+	// Err is the error of the individual call, it's set once the batch was sent
+	Err error
+}
+
+// Batch queues calls to send them to aria2 in a single round-trip using system.multicall.
+// The replies are only filled in once Send has been called.
+type Batch struct {
+	client *Client
+	calls  []*BatchCall
+}
+
+// Batch creates a new empty batch.
+func (c *Client) Batch() *Batch {
+	return &Batch{client: c}
+}
+
+// Len returns the number of queued calls.
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// Call queues a call of the given method.
+// reply is a pointer to the value the result is decoded into, it may be nil.
+func (b *Batch) Call(method string, params []interface{}, reply interface{}) *BatchCall {
+	call := &BatchCall{Method: method, Params: params, Reply: reply}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// TellStatus queues a call of Client.TellStatus() which stores the status in status.
+func (b *Batch) TellStatus(status *Status, gid string, keys ...string) *BatchCall {
+	params := []interface{}{gid}
+	if len(keys) > 0 {
+		params = append(params, keys)
+	}
+
+	return b.Call("aria2.tellStatus", params, status)
+}
+
+// GetURIs queues a call of Client.GetURIs() which stores the URIs in uris.
+func (b *Batch) GetURIs(uris *[]URI, gid string) *BatchCall {
+	return b.Call("aria2.getUris", []interface{}{gid}, uris)
+}
+
+// GetFiles queues a call of Client.GetFiles() which stores the files in files.
+func (b *Batch) GetFiles(files *[]File, gid string) *BatchCall {
+	return b.Call("aria2.getFiles", []interface{}{gid}, files)
+}
+
+// GetPeers queues a call of Client.GetPeers() which stores the peers in peers.
+func (b *Batch) GetPeers(peers *[]Peer, gid string) *BatchCall {
+	return b.Call("aria2.getPeers", []interface{}{gid}, peers)
+}
+
+// GetServers queues a call of Client.GetServers() which stores the servers in servers.
+func (b *Batch) GetServers(servers *[]FileServers, gid string) *BatchCall {
+	return b.Call("aria2.getServers", []interface{}{gid}, servers)
+}
+
+// GetOption queues a call of Client.GetOption() which stores the options in options.
+func (b *Batch) GetOption(options *Options, gid string) *BatchCall {
+	return b.Call("aria2.getOption", []interface{}{gid}, options)
+}
+
+type multicallEntry struct {
+	MethodName string        `json:"methodName"`
+	Params     []interface{} `json:"params"`
+}
+
+// Send sends all queued calls to aria2.
+// The returned error only concerns the batch as a whole,
+// the results of the individual calls are stored in the BatchCalls.
+func (b *Batch) Send() error {
+	if len(b.calls) == 0 {
+		return nil
+	}
+
+	entries := make([]multicallEntry, len(b.calls))
+	for i, call := range b.calls {
+		entries[i] = multicallEntry{MethodName: call.Method, Params: b.client.withSecret(call.Params)}
+	}
+
+	// system.multicall itself doesn't require the secret
+	var reply []json.RawMessage
+	if err := b.client.rawCall("system.multicall", []interface{}{entries}, &reply); err != nil {
+		return err
+	}
+
+	if len(reply) != len(b.calls) {
+		return fmt.Errorf("expected %d results, got %d", len(b.calls), len(reply))
+	}
+
+	for i, call := range b.calls {
+		call.Err = decodeMulticallResult(reply[i], call.Reply)
+	}
+
+	return nil
+}
+
+// decodeMulticallResult decodes a single result of system.multicall.
+// Successful results are wrapped in an array, failures are fault structs.
+func decodeMulticallResult(data json.RawMessage, reply interface{}) error {
+	var result []json.RawMessage
+	if err := json.Unmarshal(data, &result); err != nil {
+		fault := new(Fault)
+		if err := json.Unmarshal(data, fault); err != nil {
+			return err
+		}
+
+		return fault
+	}
+
+	if len(result) != 1 {
+		return fmt.Errorf("expected a single value, got %d", len(result))
+	}
+
+	if reply == nil {
+		return nil
+	}
+
+	return json.Unmarshal(result[0], reply)
+}
@@ -0,0 +1,19 @@
+package aria2
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeMulticallResult(t *testing.T) {
+	var status Status
+	err := decodeMulticallResult([]byte(`[{"gid": "2089b05ecca3d829", "status": "active"}]`), &status)
+	assert.NoError(t, err)
+	assert.Equal(t, "2089b05ecca3d829", status.GID)
+	assert.Equal(t, StatusActive, status.Status)
+
+	err = decodeMulticallResult([]byte(`{"code": 1, "message": "GID 2089b05ecca3d829 is not found"}`), &status)
+	assert.Equal(t, &Fault{Code: 1, Message: "GID 2089b05ecca3d829 is not found"}, err)
+
+	assert.NoError(t, decodeMulticallResult([]byte(`["OK"]`), nil))
+}
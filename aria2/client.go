@@ -10,14 +10,19 @@ package aria2
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/MyAnimeStream/arias/aria2/rpc"
 	"github.com/cenkalti/rpc2"
 	"github.com/cenkalti/rpc2/jsonrpc"
 	"github.com/gorilla/websocket"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sync"
+	"time"
 )
 
 // URIs creates a string slice from the given uris
@@ -25,138 +30,372 @@ func URIs(uris ...string) []string {
 	return uris
 }
 
-type EventListener func(event *DownloadEvent)
+// DialOptions configure the connection to the aria2 rpc interface.
+type DialOptions struct {
+	// Secret is the secret token set using the --rpc-secret option of aria2
+	Secret string
+	// Header is sent along with the websocket handshake or every HTTP request
+	Header http.Header
+	// HTTPClient is used to send the requests of the HTTP transport
+	HTTPClient *http.Client
+	// PollInterval is the interval at which the HTTP transport polls for changes to emulate notifications
+	PollInterval time.Duration
+}
 
-type Client struct {
-	ws        *websocket.Conn
-	rpcClient *rpc2.Client
+// DialOption changes the DialOptions.
+type DialOption func(options *DialOptions)
+
+// WithSecret authorizes all calls using the given secret token.
+func WithSecret(secret string) DialOption {
+	return func(options *DialOptions) {
+		options.Secret = secret
+	}
+}
 
-	closed     bool
-	listeners  map[string][]EventListener
-	activeGIDs map[string]chan error
+// WithHeader adds the given header to the websocket handshake or the HTTP requests.
+func WithHeader(header http.Header) DialOption {
+	return func(options *DialOptions) {
+		options.Header = header
+	}
+}
+
+// WithHTTPClient sets the client used by the HTTP transport.
+func WithHTTPClient(client *http.Client) DialOption {
+	return func(options *DialOptions) {
+		options.HTTPClient = client
+	}
+}
+
+// WithPollInterval sets the interval at which the HTTP transport polls for changes.
+func WithPollInterval(interval time.Duration) DialOption {
+	return func(options *DialOptions) {
+		options.PollInterval = interval
+	}
+}
+
+// minReconnectDelay and maxReconnectDelay limit the delay between two attempts to reconnect.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// ErrClosed is returned when calling a method of a closed client.
+var ErrClosed = errors.New("client is closed")
+
+type Client struct {
+	url     string
+	options DialOptions
+	events  *eventDispatcher
+
+	// mu protects the transport and the waiters
+	mu        sync.RWMutex
+	transport transport
+	closed    bool
+	// waiters contains the channels of everyone waiting for a download to finish
+	waiters map[string][]chan error
 }
 
 // Dial creates a new connection to an aria2 rpc interface.
 // It returns a new client.
-func Dial(url string) (client Client, err error) {
-	dialer := websocket.Dialer{}
+//
+// The transport is selected by the scheme of the url.
+// Websocket connections (ws://, wss://) receive notifications from aria2.
+// If the connection is lost, the client reconnects in the background.
+// Calls made while disconnected fail, but downloads which are being waited for
+// are checked once the connection is reestablished.
+//
+// HTTP connections (http://, https://) send every call as a separate request.
+// As aria2 can't send notifications over HTTP, they are emulated by polling the status of the downloads.
+func Dial(rawUrl string, opts ...DialOption) (client *Client, err error) {
+	options := DialOptions{
+		Header: http.Header{},
+		// Without a timeout, calls to an unreachable server could block forever
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		PollInterval: defaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
 
-	ws, _, err := dialer.Dial(url, http.Header{})
+	u, err := url.Parse(rawUrl)
 	if err != nil {
 		return
 	}
 
+	client = &Client{
+		url:     rawUrl,
+		options: options,
+		events:  newEventDispatcher(),
+		waiters: make(map[string][]chan error),
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+		var t *wsTransport
+		t, err = client.connect()
+		if err != nil {
+			return nil, err
+		}
+
+		go client.run(t)
+	case "http", "https":
+		client.transport = newHTTPTransport(rawUrl, options.HTTPClient, options.Header)
+		go client.poll()
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	return
+}
+
+// connect opens a new websocket connection and registers the notification handlers.
+func (c *Client) connect() (*wsTransport, error) {
+	dialer := websocket.Dialer{}
+
+	ws, _, err := dialer.Dial(c.url, c.options.Header)
+	if err != nil {
+		return nil, err
+	}
+
 	rwc := rpc.NewReadWriteCloser(ws)
 	codec := jsonrpc.NewJSONCodec(&rwc)
 	rpcClient := rpc2.NewClientWithCodec(codec)
 
-	client = Client{ws: ws, rpcClient: rpcClient,
-		closed:     false,
-		listeners:  make(map[string][]EventListener),
-		activeGIDs: make(map[string]chan error),
+	for _, eventType := range EventTypes {
+		eventType := eventType
+		rpcClient.Handle(eventType.Method(), func(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
+			c.onEvent(eventType, event)
+			return nil
+		})
 	}
 
-	rpcClient.Handle("aria2.onDownloadStart", client.onDownloadStart)
-	rpcClient.Handle("aria2.onDownloadPause", client.onDownloadPause)
-	rpcClient.Handle("aria2.onDownloadStop", client.onDownloadStop)
-	rpcClient.Handle("aria2.onDownloadComplete", client.onDownloadComplete)
-	rpcClient.Handle("aria2.onDownloadError", client.onDownloadError)
-	rpcClient.Handle("aria2.onBtDownloadComplete", client.onBtDownloadComplete)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	go rpcClient.Run()
+	if c.closed {
+		_ = ws.Close()
+		return nil, ErrClosed
+	}
 
-	return
+	t := &wsTransport{ws: ws, rpcClient: rpcClient}
+	c.transport = t
+
+	return t, nil
 }
 
-// Close closes the connection to the aria2 rpc interface.
-// The client becomes unusable after that point.
-func (c *Client) Close() error {
-	c.closed = true
+// run handles the websocket connection until the client is closed.
+// Whenever the connection is lost, it reconnects with an exponential backoff.
+func (c *Client) run(t *wsTransport) {
+	for {
+		t.rpcClient.Run()
+
+		delay := minReconnectDelay
+		for {
+			if c.isClosed() {
+				return
+			}
+
+			var err error
+			t, err = c.connect()
+			if err == nil {
+				break
+			} else if err == ErrClosed {
+				return
+			}
+
+			log.Printf("aria2: reconnecting to %s failed, retrying in %s: %s\n", c.url, delay, err)
+			time.Sleep(delay)
+
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
 
-	err := c.rpcClient.Close()
-	wsErr := c.ws.Close()
-	if err == nil {
-		err = wsErr
+		// The responses are only read by Run, so the check has to happen concurrently
+		go c.reconcile()
 	}
-
-	return err
 }
 
-func (c *Client) String() string {
-	return fmt.Sprintf("Aria2Client")
+func (c *Client) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.closed
 }
 
-func (c *Client) onEvent(name string, event *DownloadEvent) {
-	listeners, ok := c.listeners[name]
-	if !ok {
-		return
+// downloadResultKeys are the keys of Status required by downloadResult.
+var downloadResultKeys = []string{"gid", "status", "errorCode", "errorMessage", "seeder", "files"}
+
+// downloadResult reports whether the download has finished and if so, whether it was successful.
+// BitTorrent downloads which are seeding are considered to be finished.
+func downloadResult(status Status) (finished bool, err error) {
+	switch status.Status {
+	case StatusCompleted:
+		return true, nil
+	case StatusError:
+		return true, NewDownloadError(status)
+	case StatusRemoved:
+		return true, errors.New("download stopped")
+	case StatusActive:
+		return status.Seeder, nil
 	}
 
-	for _, listener := range listeners {
-		go listener(event)
+	return false, nil
+}
+
+// reconcile checks the downloads which are being waited for.
+// Notifications which were sent while the client was disconnected are lost,
+// so downloads which finished in the meantime have to be resolved manually.
+func (c *Client) reconcile() {
+	c.check(c.waited())
+}
+
+// waited returns the GIDs of the downloads which are waited for.
+func (c *Client) waited() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	gids := make([]string, 0, len(c.waiters))
+	for gid := range c.waiters {
+		gids = append(gids, gid)
 	}
+
+	return gids
 }
 
-func (c *Client) onDownloadStart(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
-	c.onEvent("downloadStart", event)
-	return nil
+// check resolves the waiters of the given downloads if they have finished or can't be found anymore.
+// Downloads which couldn't be checked are checked again by the next call.
+func (c *Client) check(gids []string) {
+	for _, gid := range gids {
+		status, err := c.TellStatus(gid, downloadResultKeys...)
+		if isNotFound(err) {
+			c.resolve(gid, &LostError{GID: gid, Err: err})
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		if finished, result := downloadResult(status); finished {
+			c.resolve(gid, result)
+		}
+	}
 }
-func (c *Client) onDownloadPause(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
-	c.onEvent("downloadPause", event)
-	return nil
+
+// watch registers a channel which receives the result of the download once it finishes.
+// The returned function has to be called once the channel is no longer needed.
+func (c *Client) watch(gid string) (<-chan error, func()) {
+	channel := make(chan error, 1)
+
+	c.mu.Lock()
+	c.waiters[gid] = append(c.waiters[gid], channel)
+	c.mu.Unlock()
+
+	return channel, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		waiters := c.waiters[gid]
+		for i, waiter := range waiters {
+			if waiter == channel {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(waiters) == 0 {
+			delete(c.waiters, gid)
+		} else {
+			c.waiters[gid] = waiters
+		}
+	}
 }
-func (c *Client) onDownloadStop(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
-	c.onEvent("downloadStop", event)
-	channel, ok := c.activeGIDs[event.GID]
-	if ok {
-		channel <- errors.New("download stopped")
+
+// resolve passes the result of a download to everyone waiting for it.
+func (c *Client) resolve(gid string, err error) {
+	c.mu.Lock()
+	waiters := c.waiters[gid]
+	delete(c.waiters, gid)
+	c.mu.Unlock()
+
+	// Every channel is removed before sending, so the buffer can't be full
+	for _, waiter := range waiters {
+		waiter <- err
 	}
-	return nil
 }
-func (c *Client) onDownloadComplete(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
-	c.onEvent("downloadComplete", event)
-	channel, ok := c.activeGIDs[event.GID]
-	if ok {
-		channel <- nil
+
+// Close closes the connection to the aria2 rpc interface.
+// The client becomes unusable after that point.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	t := c.transport
+	c.mu.Unlock()
+
+	return t.Close()
+}
+
+// withSecret prepends the secret token to the params if the client has one.
+func (c *Client) withSecret(params []interface{}) []interface{} {
+	if c.options.Secret != "" {
+		params = append([]interface{}{"token:" + c.options.Secret}, params...)
 	}
 
-	return nil
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	return params
+}
+
+// call calls the given method of the aria2 rpc interface.
+// If the client has a secret, it's passed as the first parameter.
+func (c *Client) call(method string, params []interface{}, reply interface{}) error {
+	return c.rawCall(method, c.withSecret(params), reply)
 }
-func (c *Client) onDownloadError(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
-	c.onEvent("downloadError", event)
-	channel, ok := c.activeGIDs[event.GID]
-	if ok {
-		channel <- errors.New("download encountered error")
+
+// rawCall calls the given method with the params as they are.
+func (c *Client) rawCall(method string, params []interface{}, reply interface{}) error {
+	c.mu.RLock()
+	t, closed := c.transport, c.closed
+	c.mu.RUnlock()
+
+	if closed {
+		return ErrClosed
 	}
-	return nil
+
+	return t.Call(method, params, reply)
 }
-func (c *Client) onBtDownloadComplete(_ *rpc2.Client, event *DownloadEvent, _ *interface{}) error {
-	c.onEvent("btDownloadComplete", event)
-	return nil
+
+func (c *Client) String() string {
+	return fmt.Sprintf("Aria2Client")
 }
 
-// Subscribe registers the given listener for an event.
-// The listener will be called every time the event occurs.
-func (c *Client) Subscribe(name string, listener EventListener) {
-	listeners, ok := c.listeners[name]
-	if !ok {
-		listeners = make([]EventListener, 1)
-		c.listeners[name] = listeners
+// onEvent handles the notifications sent by aria2.
+func (c *Client) onEvent(eventType EventType, event *DownloadEvent) {
+	c.events.dispatch(eventType, event)
+
+	switch eventType {
+	case EventDownloadComplete, EventBtDownloadComplete:
+		c.resolve(event.GID, nil)
+	case EventDownloadStop:
+		c.resolve(event.GID, errors.New("download stopped"))
+	case EventDownloadError:
+		// The details are filled in from the status of the download once it's available
+		c.resolve(event.GID, &DownloadError{GID: event.GID, ExitStatus: UnknownError, Description: UnknownError.Description()})
 	}
+}
 
-	c.listeners[name] = append(listeners, listener)
+// Subscribe registers the given listener for an event.
+// The listener will be called in a separate goroutine every time the event occurs
+// until the returned subscription is cancelled.
+func (c *Client) Subscribe(eventType EventType, listener EventListener) *Subscription {
+	return c.events.subscribe(eventType, listener)
 }
 
 // WaitForDownload waits for a download denoted by its gid to finish.
 func (c *Client) WaitForDownload(gid string) error {
-	channel, ok := c.activeGIDs[gid]
-	if !ok {
-		channel = make(chan error, 1)
-		c.activeGIDs[gid] = channel
-	}
-
-	err := <-channel
-	delete(c.activeGIDs, gid)
+	_, err := c.WaitForDownloadWithContext(context.Background(), gid)
 	return err
 }
 
@@ -175,38 +414,124 @@ func (c *Client) DownloadWithContext(ctx context.Context, uris []string, options
 		return
 	}
 
-	downloadDone := make(chan error, 1)
+	return c.WaitForDownloadWithContext(ctx, gid.GID)
+}
+
+// DownloadTorrent adds a new BitTorrent download and waits for it to complete.
+// It returns the status of the finished download.
+func (c *Client) DownloadTorrent(torrent []byte, webSeeds []string, options *Options) (status Status, err error) {
+	return c.DownloadTorrentWithContext(context.Background(), torrent, webSeeds, options)
+}
+
+// DownloadTorrentWithContext adds a new BitTorrent download and waits for it to complete.
+// The passed context can be used to cancel the download.
+// It returns the status of the finished download.
+func (c *Client) DownloadTorrentWithContext(ctx context.Context, torrent []byte, webSeeds []string, options *Options) (status Status, err error) {
+	gid, err := c.AddTorrent(torrent, webSeeds, options)
+	if err != nil {
+		return
+	}
+
+	return c.WaitForDownloadWithContext(ctx, gid.GID)
+}
+
+// DownloadMetalink adds the downloads of a Metalink and waits for all of them to complete.
+// It returns the status of every finished download.
+func (c *Client) DownloadMetalink(metalink []byte, options *Options) (statuses []Status, err error) {
+	return c.DownloadMetalinkWithContext(context.Background(), metalink, options)
+}
 
-	go func() {
-		downloadDone <- gid.WaitForDownload()
-	}()
+// DownloadMetalinkWithContext adds the downloads of a Metalink and waits for all of them to complete.
+// The passed context can be used to cancel the downloads.
+// It returns the status of every finished download.
+func (c *Client) DownloadMetalinkWithContext(ctx context.Context, metalink []byte, options *Options) (statuses []Status, err error) {
+	gids, err := c.AddMetalink(metalink, options)
+	if err != nil {
+		return
+	}
 
-	select {
-	case <-downloadDone:
-		status, err = gid.TellStatus()
+	for i, gid := range gids {
+		var status Status
+		status, err = c.WaitForDownloadWithContext(ctx, gid.GID)
 		if err != nil {
+			for _, remaining := range gids[i+1:] {
+				_ = remaining.Delete()
+			}
+			return
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return
+}
+
+// WaitForDownloadWithContext waits for a download denoted by its gid to finish.
+// The passed context can be used to cancel the download.
+// It returns the status of the finished download.
+// If aria2 reports that the download failed, the error is a *DownloadError.
+func (c *Client) WaitForDownloadWithContext(ctx context.Context, gid string) (status Status, err error) {
+	// Watching before checking the status ensures that no notification is missed,
+	// even if the download finishes right after it was added.
+	done, stop := c.watch(gid)
+	defer stop()
+
+	status, err = c.TellStatus(gid, downloadResultKeys...)
+	if err != nil {
+		return
+	}
+
+	if finished, _ := downloadResult(status); !finished {
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			_ = c.Delete(gid)
+			err = errors.New("download cancelled")
 			return
 		}
-	case <-ctx.Done():
-		_ = gid.Delete()
-		err = errors.New("download cancelled")
+	}
+
+	var statusErr error
+	status, statusErr = c.TellStatus(gid)
+	if statusErr != nil {
+		if err == nil {
+			err = statusErr
+		}
+		return
+	}
+
+	if finished, result := downloadResult(status); finished {
+		err = result
 	}
 
 	return
 }
 
 // Delete removes the download denoted by gid and deletes all corresponding files.
+// Downloads which have already stopped are removed from the download results instead.
 func (c *Client) Delete(gid string) (err error) {
-	err = c.Remove(gid)
+	status, err := c.TellStatus(gid, "status", "files")
+	if err != nil {
+		return
+	}
+
+	switch status.Status {
+	case StatusCompleted, StatusError, StatusRemoved:
+		err = c.RemoveDownloadResult(gid)
+	default:
+		err = c.Remove(gid)
+	}
 	if err != nil {
 		return
 	}
 
-	files, err := c.GetFiles(gid)
-	if err == nil {
-		for _, file := range files {
-			_ = os.Remove(file.Path)
+	for _, file := range status.Files {
+		if file.Path == "" {
+			continue
 		}
+
+		_ = os.Remove(file.Path)
+		_ = os.Remove(file.Path + ".aria2")
 	}
 
 	return
@@ -233,16 +558,62 @@ func (c *Client) AddUri(uris []string, options *Options) (GID, error) {
 	}
 
 	var reply string
-	err := c.rpcClient.Call("aria2.addUri", args, &reply)
+	err := c.call("aria2.addUri", args, &reply)
 
 	return c.GetGID(reply), err
 }
 
+// AddTorrent adds a BitTorrent download by uploading the contents of a ".torrent" file.
+// If you want to add a BitTorrent Magnet URI, use the AddUri() method instead.
+// webSeeds is a slice of URIs used for Web-seeding.
+// For single file torrents, the URI can be a complete URI pointing to the resource;
+// if URI ends with /, name in torrent file is added.
+// For multi-file torrents, name and path in torrent are added to form a URI for each file.
+//
+// The new download is appended to the end of the queue.
+// This method returns the GID of the newly registered download.
+func (c *Client) AddTorrent(torrent []byte, webSeeds []string, options *Options) (GID, error) {
+	if webSeeds == nil {
+		webSeeds = []string{}
+	}
+
+	args := []interface{}{base64.StdEncoding.EncodeToString(torrent), webSeeds}
+	if options != nil {
+		args = append(args, options)
+	}
+
+	var reply string
+	err := c.call("aria2.addTorrent", args, &reply)
+
+	return c.GetGID(reply), err
+}
+
+// AddMetalink adds a Metalink download by uploading the contents of a ".metalink" file.
+//
+// The new downloads are appended to the end of the queue.
+// This method returns the GIDs of the newly registered downloads.
+func (c *Client) AddMetalink(metalink []byte, options *Options) ([]GID, error) {
+	args := []interface{}{base64.StdEncoding.EncodeToString(metalink)}
+	if options != nil {
+		args = append(args, options)
+	}
+
+	var reply []string
+	err := c.call("aria2.addMetalink", args, &reply)
+
+	gids := make([]GID, len(reply))
+	for i, gid := range reply {
+		gids[i] = c.GetGID(gid)
+	}
+
+	return gids, err
+}
+
 // Remove removes the download denoted by gid.
 // If the specified download is in progress, it is first stopped.
 // The status of the removed download becomes removed.
 func (c *Client) Remove(gid string) error {
-	return c.rpcClient.Call("aria2.remove", []interface{}{gid}, nil)
+	return c.call("aria2.remove", []interface{}{gid}, nil)
 }
 
 // ForceRemove removes the download denoted by gid.
@@ -250,7 +621,7 @@ func (c *Client) Remove(gid string) error {
 // without performing any actions which take time, such as contacting BitTorrent trackers to
 // unregister the download first.
 func (c *Client) ForceRemove(gid string) error {
-	return c.rpcClient.Call("aria2.forceRemove", []interface{}{gid}, nil)
+	return c.call("aria2.forceRemove", []interface{}{gid}, nil)
 }
 
 // Pause pauses the download denoted by gid.
@@ -258,12 +629,12 @@ func (c *Client) ForceRemove(gid string) error {
 // the download is placed in the front of the queue. While the status is paused,
 // the download is not started. To change status to waiting, use the Unpause() method.
 func (c *Client) Pause(gid string) error {
-	return c.rpcClient.Call("aria2.pause", []interface{}{gid}, nil)
+	return c.call("aria2.pause", []interface{}{gid}, nil)
 }
 
 // PauseAll is equal to calling Pause() for every active/waiting download.
 func (c *Client) PauseAll() error {
-	return c.rpcClient.Call("aria2.pauseAll", nil, nil)
+	return c.call("aria2.pauseAll", nil, nil)
 }
 
 // ForcePause pauses the download denoted by gid.
@@ -271,23 +642,23 @@ func (c *Client) PauseAll() error {
 // without performing any actions which take time, such as contacting BitTorrent trackers to
 // unregister the download first.
 func (c *Client) ForcePause(gid string) error {
-	return c.rpcClient.Call("aria2.forcePause", []interface{}{gid}, nil)
+	return c.call("aria2.forcePause", []interface{}{gid}, nil)
 }
 
 // ForcePauseAll is equal to calling ForcePause() for every active/waiting download.
 func (c *Client) ForcePauseAll() error {
-	return c.rpcClient.Call("aria2.forcePauseAll", nil, nil)
+	return c.call("aria2.forcePauseAll", nil, nil)
 }
 
 // Unpause changes the status of the download denoted by gid from paused to waiting,
 // making the download eligible to be restarted.
 func (c *Client) Unpause(gid string) error {
-	return c.rpcClient.Call("aria2.unpause", []interface{}{gid}, nil)
+	return c.call("aria2.unpause", []interface{}{gid}, nil)
 }
 
 // UnpauseAll is equal to calling Unpause() for every paused download.
 func (c *Client) UnpauseAll() error {
-	return c.rpcClient.Call("aria2.unpauseAll", nil, nil)
+	return c.call("aria2.unpauseAll", nil, nil)
 }
 
 // TellStatus returns the progress of the download denoted by gid.
@@ -301,7 +672,7 @@ func (c *Client) TellStatus(gid string, keys ...string) (Status, error) {
 	}
 
 	var reply Status
-	err := c.rpcClient.Call("aria2.tellStatus", args, &reply)
+	err := c.call("aria2.tellStatus", args, &reply)
 
 	return reply, err
 }
@@ -310,7 +681,7 @@ func (c *Client) TellStatus(gid string, keys ...string) (Status, error) {
 // The response is a slice of URI.
 func (c *Client) GetURIs(gid string) ([]URI, error) {
 	var reply []URI
-	err := c.rpcClient.Call("aria2.getUris", []interface{}{gid}, &reply)
+	err := c.call("aria2.getUris", []interface{}{gid}, &reply)
 
 	return reply, err
 }
@@ -319,11 +690,19 @@ func (c *Client) GetURIs(gid string) ([]URI, error) {
 // The response is a slice of File.
 func (c *Client) GetFiles(gid string) ([]File, error) {
 	var reply []File
-	err := c.rpcClient.Call("aria2.getFiles", []interface{}{gid}, &reply)
+	err := c.call("aria2.getFiles", []interface{}{gid}, &reply)
 
 	return reply, err
 }
 
+// ChangeOption changes the options of the download denoted by gid dynamically.
+// Only the options which are set are changed.
+// Some options, like SelectFile, can't be changed while the download is active,
+// in that case the download has to be paused first.
+func (c *Client) ChangeOption(gid string, options *Options) error {
+	return c.call("aria2.changeOption", []interface{}{gid, options}, nil)
+}
+
 type PositionSetBehaviour string
 
 const (
@@ -346,7 +725,156 @@ func (c *Client) ChangePosition(gid string, pos int, how PositionSetBehaviour) (
 	}
 
 	var reply int
-	err := c.rpcClient.Call("aria2.changePosition", args, &reply)
+	err := c.call("aria2.changePosition", args, &reply)
 
 	return reply, err
 }
+
+// ChangeURI removes the URIs in delURIs from and appends the URIs in addURIs
+// to the file denoted by fileIndex of the download denoted by gid.
+// fileIndex is 1-based.
+// If position is not negative, the URIs are inserted at that position instead of being appended.
+// The response contains the number of URIs deleted and added.
+func (c *Client) ChangeURI(gid string, fileIndex int, delURIs []string, addURIs []string, position int) (deleted int, added int, err error) {
+	if delURIs == nil {
+		delURIs = []string{}
+	}
+	if addURIs == nil {
+		addURIs = []string{}
+	}
+
+	args := []interface{}{gid, fileIndex, delURIs, addURIs}
+	if position >= 0 {
+		args = append(args, position)
+	}
+
+	var reply []int
+	err = c.call("aria2.changeUri", args, &reply)
+	if err == nil && len(reply) == 2 {
+		deleted, added = reply[0], reply[1]
+	}
+
+	return
+}
+
+// tellStatuses calls a method which returns a list of statuses.
+func (c *Client) tellStatuses(method string, args []interface{}, keys []string) ([]Status, error) {
+	if len(keys) > 0 {
+		args = append(args, keys)
+	}
+
+	var reply []Status
+	err := c.call(method, args, &reply)
+
+	return reply, err
+}
+
+// TellActive returns the statuses of the active downloads.
+// The keys work just like in TellStatus().
+func (c *Client) TellActive(keys ...string) ([]Status, error) {
+	return c.tellStatuses("aria2.tellActive", nil, keys)
+}
+
+// TellWaiting returns the statuses of the waiting downloads, including paused ones.
+// offset is the position in the queue to start at, if it's negative it's counted from the end of the queue.
+// num is the maximum number of downloads to return.
+// The keys work just like in TellStatus().
+func (c *Client) TellWaiting(offset int, num int, keys ...string) ([]Status, error) {
+	return c.tellStatuses("aria2.tellWaiting", []interface{}{offset, num}, keys)
+}
+
+// TellStopped returns the statuses of the stopped downloads.
+// offset and num work just like in TellWaiting(), the keys just like in TellStatus().
+func (c *Client) TellStopped(offset int, num int, keys ...string) ([]Status, error) {
+	return c.tellStatuses("aria2.tellStopped", []interface{}{offset, num}, keys)
+}
+
+// GetPeers returns the peers of the BitTorrent download denoted by gid.
+func (c *Client) GetPeers(gid string) ([]Peer, error) {
+	var reply []Peer
+	err := c.call("aria2.getPeers", []interface{}{gid}, &reply)
+
+	return reply, err
+}
+
+// GetServers returns the servers currently connected to for the HTTP(S)/FTP/SFTP download denoted by gid.
+func (c *Client) GetServers(gid string) ([]FileServers, error) {
+	var reply []FileServers
+	err := c.call("aria2.getServers", []interface{}{gid}, &reply)
+
+	return reply, err
+}
+
+// GetOption returns the options of the download denoted by gid.
+// Options which don't have a default value and which weren't set aren't included.
+func (c *Client) GetOption(gid string) (Options, error) {
+	var reply Options
+	err := c.call("aria2.getOption", []interface{}{gid}, &reply)
+
+	return reply, err
+}
+
+// GetGlobalOption returns the global options.
+func (c *Client) GetGlobalOption() (Options, error) {
+	var reply Options
+	err := c.call("aria2.getGlobalOption", nil, &reply)
+
+	return reply, err
+}
+
+// ChangeGlobalOption changes the global options dynamically.
+// Only the options which are set are changed.
+func (c *Client) ChangeGlobalOption(options *Options) error {
+	return c.call("aria2.changeGlobalOption", []interface{}{options}, nil)
+}
+
+// GetGlobalStat returns the global statistics such as the overall download and upload speeds.
+func (c *Client) GetGlobalStat() (GlobalStat, error) {
+	var reply GlobalStat
+	err := c.call("aria2.getGlobalStat", nil, &reply)
+
+	return reply, err
+}
+
+// PurgeDownloadResult removes completed/error/removed downloads to free memory.
+func (c *Client) PurgeDownloadResult() error {
+	return c.call("aria2.purgeDownloadResult", nil, nil)
+}
+
+// RemoveDownloadResult removes the completed/error/removed download denoted by gid from memory.
+func (c *Client) RemoveDownloadResult(gid string) error {
+	return c.call("aria2.removeDownloadResult", []interface{}{gid}, nil)
+}
+
+// GetVersion returns the version of aria2 and the list of enabled features.
+func (c *Client) GetVersion() (VersionInfo, error) {
+	var reply VersionInfo
+	err := c.call("aria2.getVersion", nil, &reply)
+
+	return reply, err
+}
+
+// GetSessionInfo returns the session information.
+func (c *Client) GetSessionInfo() (SessionInfo, error) {
+	var reply SessionInfo
+	err := c.call("aria2.getSessionInfo", nil, &reply)
+
+	return reply, err
+}
+
+// SaveSession saves the current session to the file specified by the --save-session option.
+func (c *Client) SaveSession() error {
+	return c.call("aria2.saveSession", nil, nil)
+}
+
+// Shutdown shuts down aria2.
+func (c *Client) Shutdown() error {
+	return c.call("aria2.shutdown", nil, nil)
+}
+
+// ForceShutdown shuts down aria2.
+// This method behaves like Shutdown() without performing any actions which take time,
+// such as contacting BitTorrent trackers to unregister downloads first.
+func (c *Client) ForceShutdown() error {
+	return c.call("aria2.forceShutdown", nil, nil)
+}
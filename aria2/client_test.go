@@ -0,0 +1,318 @@
+package aria2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientWithSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		params []interface{}
+		want   []interface{}
+	}{
+		{"no secret", "", []interface{}{"2089b05ecca3d829"}, []interface{}{"2089b05ecca3d829"}},
+		{"no secret or params", "", nil, []interface{}{}},
+		{"secret", "abc", []interface{}{"2089b05ecca3d829", 1}, []interface{}{"token:abc", "2089b05ecca3d829", 1}},
+		{"secret without params", "abc", nil, []interface{}{"token:abc"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Client{options: DialOptions{Secret: test.secret}}
+			assert.Equal(t, test.want, c.withSecret(test.params))
+		})
+	}
+}
+
+func TestSecretIsSent(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string][]json.RawMessage)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Id     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// ignore the polling of the HTTP transport
+		if req.Method == "system.multicall" && strings.Contains(string(req.Params[0]), "aria2.tellActive") {
+			_, _ = fmt.Fprintf(w, `{"id": %d, "jsonrpc": "2.0", "result": [[[]], [[]], [[]]]}`, req.Id)
+			return
+		}
+
+		mu.Lock()
+		requests[req.Method] = req.Params
+		mu.Unlock()
+
+		result := `"OK"`
+		if req.Method == "system.multicall" {
+			result = `[["OK"], ["OK"]]`
+		}
+		_, _ = fmt.Fprintf(w, `{"id": %d, "jsonrpc": "2.0", "result": %s}`, req.Id, result)
+	}))
+	defer srv.Close()
+
+	client, err := Dial(srv.URL, WithSecret("abc"))
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	require.NoError(t, client.Pause("2089b05ecca3d829"))
+
+	batch := client.Batch()
+	batch.Call("aria2.unpause", []interface{}{"2089b05ecca3d829"}, nil)
+	batch.Call("aria2.pauseAll", nil, nil)
+	require.NoError(t, batch.Send())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.JSONEq(t, `"token:abc"`, string(requests["aria2.pause"][0]))
+
+	// system.multicall doesn't take a secret, but each of its calls does
+	require.Len(t, requests["system.multicall"], 1)
+	assert.JSONEq(t, `[
+		{"methodName": "aria2.unpause", "params": ["token:abc", "2089b05ecca3d829"]},
+		{"methodName": "aria2.pauseAll", "params": ["token:abc"]}
+	]`, string(requests["system.multicall"][0]))
+}
+
+// wsAria2 is an aria2 rpc interface serving the statuses of its downloads over websocket connections.
+type wsAria2 struct {
+	mu       sync.Mutex
+	statuses map[string]Status
+	conns    []*websocket.Conn
+	// connects is the amount of accepted connections
+	connects int
+	// refuse is the amount of connection attempts which are still refused
+	refuse int
+}
+
+// newWSAria2 starts a websocket aria2 and returns its url.
+func newWSAria2(t *testing.T) (*wsAria2, string) {
+	a := &wsAria2{statuses: make(map[string]Status)}
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		if a.refuse > 0 {
+			a.refuse--
+			a.mu.Unlock()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		a.mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		a.mu.Lock()
+		a.conns = append(a.conns, conn)
+		a.connects++
+		a.mu.Unlock()
+
+		for {
+			var req struct {
+				Id     *uint64           `json:"id"`
+				Method string            `json:"method"`
+				Params []json.RawMessage `json:"params"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+
+			// responses to notifications
+			if req.Id == nil || req.Method == "" {
+				continue
+			}
+
+			res := map[string]interface{}{"id": *req.Id, "jsonrpc": "2.0"}
+			if result, fault := a.call(req.Method, req.Params); fault != nil {
+				res["error"] = map[string]interface{}{"code": fault.Code, "message": fault.Message}
+			} else {
+				res["result"] = result
+			}
+
+			if err := conn.WriteJSON(res); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return a, "ws" + strings.TrimPrefix(srv.URL, "http") + "/jsonrpc"
+}
+
+func (a *wsAria2) call(method string, params []json.RawMessage) (interface{}, *Fault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var gid string
+	if len(params) > 0 {
+		_ = json.Unmarshal(params[0], &gid)
+	}
+
+	switch method {
+	case "aria2.getVersion":
+		return VersionInfo{Version: "1.35.0"}, nil
+	case "aria2.tellStatus":
+		if status, ok := a.statuses[gid]; ok {
+			return status, nil
+		}
+	}
+
+	return nil, &Fault{Code: 1, Message: fmt.Sprintf("GID %s is not found", gid)}
+}
+
+func (a *wsAria2) setStatus(status Status) {
+	a.mu.Lock()
+	a.statuses[status.GID] = status
+	a.mu.Unlock()
+}
+
+func (a *wsAria2) removeStatus(gid string) {
+	a.mu.Lock()
+	delete(a.statuses, gid)
+	a.mu.Unlock()
+}
+
+// disconnect closes all connections.
+// The next attempts to connect are refused the given amount of times.
+func (a *wsAria2) disconnect(refuse int) {
+	a.mu.Lock()
+	a.refuse = refuse
+	conns := a.conns
+	a.conns = nil
+	a.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+func (a *wsAria2) connections() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.connects
+}
+
+// waitFor waits until the condition is met.
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition wasn't met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	a, u := newWSAria2(t)
+
+	client, err := Dial(u)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	_, err = client.GetVersion()
+	require.NoError(t, err)
+
+	// The first attempt to reconnect fails, the second one is made after the backoff
+	a.disconnect(1)
+	waitFor(t, func() bool {
+		// calls fail until the client has reconnected
+		_, err := client.GetVersion()
+		return err == nil
+	})
+	assert.Equal(t, 2, a.connections())
+
+	// Errors reported by aria2 don't break the connection
+	_, err = client.TellStatus("2089b05ecca3d829")
+	assert.Equal(t, &Fault{Code: 1, Message: "GID 2089b05ecca3d829 is not found"}, err)
+	_, err = client.GetVersion()
+	assert.NoError(t, err)
+
+	require.NoError(t, client.Close())
+	a.disconnect(0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, a.connections(), "a closed client mustn't reconnect")
+	assert.Equal(t, ErrClosed, client.Pause("2089b05ecca3d829"))
+}
+
+func TestClientReconcile(t *testing.T) {
+	a, u := newWSAria2(t)
+	gids := []string{"2089b05ecca3d829", "d2703803b52216d1", "e8c3d0ba2fbb17a4"}
+	for _, gid := range gids {
+		a.setStatus(Status{GID: gid, Status: StatusActive})
+	}
+
+	client, err := Dial(u)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	results := make(map[string]chan error)
+	for _, gid := range gids {
+		result := make(chan error, 1)
+		results[gid] = result
+
+		go func(gid string) {
+			_, err := client.WaitForDownloadWithContext(context.Background(), gid)
+			result <- err
+		}(gid)
+	}
+
+	waitFor(t, func() bool {
+		client.mu.RLock()
+		defer client.mu.RUnlock()
+		return len(client.waiters) == 3
+	})
+
+	// The notifications of these changes are lost while the client is disconnected
+	a.disconnect(0)
+	a.setStatus(Status{GID: "2089b05ecca3d829", Status: StatusCompleted})
+	a.setStatus(Status{GID: "d2703803b52216d1", Status: StatusError, ErrorCode: NetworkError})
+	a.removeStatus("e8c3d0ba2fbb17a4")
+
+	receive := func(gid string) error {
+		select {
+		case err := <-results[gid]:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("download %s wasn't resolved", gid)
+			return nil
+		}
+	}
+
+	assert.NoError(t, receive("2089b05ecca3d829"))
+
+	var downloadErr *DownloadError
+	if assert.True(t, errors.As(receive("d2703803b52216d1"), &downloadErr)) {
+		assert.Equal(t, NetworkError, downloadErr.ExitStatus)
+	}
+
+	var lostErr *LostError
+	if assert.True(t, errors.As(receive("e8c3d0ba2fbb17a4"), &lostErr)) {
+		assert.Equal(t, "e8c3d0ba2fbb17a4", lostErr.GID)
+	}
+}
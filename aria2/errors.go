@@ -0,0 +1,97 @@
+package aria2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DownloadError is returned when aria2 reports that a download failed.
+type DownloadError struct {
+	GID        string
+	ExitStatus ExitStatus
+	// Description is the human-readable description of the exit status
+	Description string
+	// Message is the error message reported by aria2, it may be empty
+	Message string
+	// URI is the URI which was in use when the download failed, it may be empty
+	URI string
+}
+
+// NewDownloadError creates the error of a failed download from its status.
+func NewDownloadError(status Status) *DownloadError {
+	return &DownloadError{
+		GID:         status.GID,
+		ExitStatus:  status.ErrorCode,
+		Description: status.ErrorCode.Description(),
+		Message:     status.ErrorMessage,
+		URI:         failingURI(status.Files),
+	}
+}
+
+// failingURI returns the URI the download was using when it failed.
+func failingURI(files []File) string {
+	var fallback string
+	for _, file := range files {
+		for _, uri := range file.URIs {
+			if uri.Status == URIUsed {
+				return uri.URI
+			}
+
+			if fallback == "" {
+				fallback = uri.URI
+			}
+		}
+	}
+
+	return fallback
+}
+
+func (e *DownloadError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Description
+	}
+
+	if e.URI != "" {
+		return fmt.Sprintf("download %s of %s failed with exit status %d: %s", e.GID, e.URI, e.ExitStatus, msg)
+	}
+
+	return fmt.Sprintf("download %s failed with exit status %d: %s", e.GID, e.ExitStatus, msg)
+}
+
+// Temporary reports whether retrying the download might succeed.
+func (e *DownloadError) Temporary() bool {
+	return e.ExitStatus.Temporary()
+}
+
+// LostError is returned for downloads which couldn't be found after the connection to aria2 was restored,
+// because aria2 was restarted without its session for example.
+type LostError struct {
+	GID string
+	Err error
+}
+
+func (e *LostError) Error() string {
+	return fmt.Sprintf("download %s was lost: %s", e.GID, e.Err)
+}
+
+func (e *LostError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports that retrying might succeed, since the download can be added again.
+func (e *LostError) Temporary() bool {
+	return true
+}
+
+// isNotFound reports whether aria2 replied that it doesn't know the download.
+// Other errors, like a failing connection, don't mean that the download is gone.
+func isNotFound(err error) bool {
+	var fault *Fault
+	if !errors.As(err, &fault) {
+		return false
+	}
+
+	return strings.Contains(fault.Message, "is not found") || strings.HasPrefix(fault.Message, "No such download")
+}
@@ -1,5 +1,38 @@
 package aria2
 
+import (
+	"strings"
+	"sync"
+)
+
+// EventType is the type of a notification sent by aria2.
+type EventType string
+
+const (
+	EventDownloadStart    EventType = "downloadStart"
+	EventDownloadPause    EventType = "downloadPause"
+	EventDownloadStop     EventType = "downloadStop"
+	EventDownloadComplete EventType = "downloadComplete"
+	EventDownloadError    EventType = "downloadError"
+	// EventBtDownloadComplete is sent when a torrent download completes but is still seeding.
+	EventBtDownloadComplete EventType = "btDownloadComplete"
+)
+
+// EventTypes contains all event types supported by aria2.
+var EventTypes = []EventType{
+	EventDownloadStart,
+	EventDownloadPause,
+	EventDownloadStop,
+	EventDownloadComplete,
+	EventDownloadError,
+	EventBtDownloadComplete,
+}
+
+// Method returns the name of the rpc method used by aria2 to send the notification.
+func (t EventType) Method() string {
+	return "aria2.on" + strings.ToUpper(string(t[:1])) + string(t[1:])
+}
+
 // DownloadEvent represents the event emitted by aria2 concerning downloads.
 // It only contains the gid of the download.
 type DownloadEvent struct {
@@ -9,3 +42,59 @@ type DownloadEvent struct {
 func (e *DownloadEvent) String() string {
 	return e.GID
 }
+
+type EventListener func(event *DownloadEvent)
+
+// Subscription is the registration of an EventListener.
+type Subscription struct {
+	dispatcher *eventDispatcher
+	eventType  EventType
+	id         uint64
+}
+
+// Unsubscribe removes the listener.
+// It's safe to call Unsubscribe multiple times.
+func (sub *Subscription) Unsubscribe() {
+	d := sub.dispatcher
+
+	d.mu.Lock()
+	delete(d.listeners[sub.eventType], sub.id)
+	d.mu.Unlock()
+}
+
+// eventDispatcher passes events to the registered listeners.
+type eventDispatcher struct {
+	mu        sync.RWMutex
+	lastId    uint64
+	listeners map[EventType]map[uint64]EventListener
+}
+
+func newEventDispatcher() *eventDispatcher {
+	return &eventDispatcher{listeners: make(map[EventType]map[uint64]EventListener)}
+}
+
+func (d *eventDispatcher) subscribe(eventType EventType, listener EventListener) *Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	listeners, ok := d.listeners[eventType]
+	if !ok {
+		listeners = make(map[uint64]EventListener)
+		d.listeners[eventType] = listeners
+	}
+
+	d.lastId++
+	listeners[d.lastId] = listener
+
+	return &Subscription{dispatcher: d, eventType: eventType, id: d.lastId}
+}
+
+// dispatch calls every listener of the event type in a separate goroutine.
+func (d *eventDispatcher) dispatch(eventType EventType, event *DownloadEvent) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, listener := range d.listeners[eventType] {
+		go listener(event)
+	}
+}
@@ -0,0 +1,41 @@
+package aria2
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEventTypeMethod(t *testing.T) {
+	assert.Equal(t, "aria2.onDownloadStart", EventDownloadStart.Method())
+	assert.Equal(t, "aria2.onBtDownloadComplete", EventBtDownloadComplete.Method())
+}
+
+func TestEventDispatcher(t *testing.T) {
+	d := newEventDispatcher()
+	received := make(chan string, 2)
+
+	sub := d.subscribe(EventDownloadComplete, func(event *DownloadEvent) {
+		received <- event.GID
+	})
+
+	d.dispatch(EventDownloadStart, &DownloadEvent{GID: "a"})
+	d.dispatch(EventDownloadComplete, &DownloadEvent{GID: "b"})
+
+	select {
+	case gid := <-received:
+		assert.Equal(t, "b", gid)
+	case <-time.After(time.Second):
+		t.Fatal("listener wasn't called")
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	d.dispatch(EventDownloadComplete, &DownloadEvent{GID: "c"})
+
+	select {
+	case gid := <-received:
+		t.Fatalf("listener was called after unsubscribing: %s", gid)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
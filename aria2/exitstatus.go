@@ -1,5 +1,7 @@
 package aria2
 
+import "fmt"
+
 // Integer returned by aria2 for failed downloads.
 // Please see https://aria2.github.io/manual/en/html/aria2c.html#exit-status
 type ExitStatus uint8
@@ -63,6 +65,8 @@ const (
 	TorrentFileCorrupt
 	// Magnet URI was bad.
 	MagnetURIBad
+	// Bad/unrecognized option was given or unexpected option argument was given.
+	BadOption
 	// The remote server was unable to handle the request due to a temporary overloading or maintenance.
 	RemoteServerHandleRequestError
 	// aria2 could not parse JSON-RPC request.
@@ -73,3 +77,93 @@ const (
 	// Checksum validation failed.
 	ChecksumValidationFailed
 )
+
+// Temporary reports whether the error is caused by a condition which might go away by itself,
+// such as a network problem or an overloaded server.
+func (s ExitStatus) Temporary() bool {
+	switch s {
+	case Timeout, DownloadSpeedTooSlow, NetworkError, NameResolutionFailed, RemoteServerHandleRequestError:
+		return true
+	default:
+		return false
+	}
+}
+
+var exitStatusDescriptions = map[ExitStatus]string{
+	Success:                        "all downloads were successful",
+	UnknownError:                   "an unknown error occurred",
+	Timeout:                        "time out occurred",
+	ResourceNotFound:               "a resource was not found",
+	ResourceNotFoundReached:        "the maximum number of \"resource not found\" errors was reached",
+	DownloadSpeedTooSlow:           "download speed was too slow",
+	NetworkError:                   "network problem occurred",
+	UnfinishedDownloads:            "there were unfinished downloads",
+	RemoteNoResume:                 "remote server did not support resume when resume was required",
+	NotEnoughDiskSpace:             "there was not enough disk space available",
+	PieceLengthMismatch:            "piece length was different from the one in the control file",
+	SameFileBeingDownloaded:        "the same file was being downloaded",
+	SameInfoHashBeingDownloaded:    "the same info hash torrent was being downloaded",
+	FileAlreadyExists:              "file already existed",
+	RenamingFailed:                 "renaming file failed",
+	CouldNotOpenExistingFile:       "could not open existing file",
+	CouldNotCreateNewFile:          "could not create new file or truncate existing file",
+	FileIOError:                    "file I/O error occurred",
+	CouldNotCreateDirectory:        "could not create directory",
+	NameResolutionFailed:           "name resolution failed",
+	MetalinkParsingFailed:          "could not parse Metalink document",
+	FTPCommandFailed:               "FTP command failed",
+	HTTPResponseHeaderBad:          "HTTP response header was bad or unexpected",
+	TooManyRedirects:               "too many redirects occurred",
+	HttpAuthorizationFailed:        "HTTP authorization failed",
+	BencodedFileParseError:         "could not parse bencoded file",
+	TorrentFileCorrupt:             "torrent file was corrupted or missing information",
+	MagnetURIBad:                   "magnet URI was bad",
+	BadOption:                      "an option was bad or unrecognized",
+	RemoteServerHandleRequestError: "remote server was unable to handle the request",
+	JSONRPCParseError:              "could not parse JSON-RPC request",
+	Reserved:                       "reserved",
+	ChecksumValidationFailed:       "checksum validation failed",
+}
+
+// Description returns a human-readable description of the exit status.
+func (s ExitStatus) Description() string {
+	if description, ok := exitStatusDescriptions[s]; ok {
+		return description
+	}
+
+	return fmt.Sprintf("unknown exit status %d", s)
+}
+
+// ErrorCategory groups exit statuses by the cause of the error.
+type ErrorCategory string
+
+const (
+	CategoryUnknown    ErrorCategory = "unknown"
+	CategoryNetwork    ErrorCategory = "network"
+	CategoryResource   ErrorCategory = "resource"
+	CategoryFilesystem ErrorCategory = "filesystem"
+	CategoryProtocol   ErrorCategory = "protocol"
+	CategoryInput      ErrorCategory = "input"
+	CategoryIntegrity  ErrorCategory = "integrity"
+)
+
+// Category returns the category of the exit status.
+func (s ExitStatus) Category() ErrorCategory {
+	switch s {
+	case Timeout, DownloadSpeedTooSlow, NetworkError, NameResolutionFailed, RemoteServerHandleRequestError:
+		return CategoryNetwork
+	case ResourceNotFound, ResourceNotFoundReached, RemoteNoResume:
+		return CategoryResource
+	case NotEnoughDiskSpace, SameFileBeingDownloaded, SameInfoHashBeingDownloaded, FileAlreadyExists, RenamingFailed,
+		CouldNotOpenExistingFile, CouldNotCreateNewFile, FileIOError, CouldNotCreateDirectory:
+		return CategoryFilesystem
+	case FTPCommandFailed, HTTPResponseHeaderBad, TooManyRedirects, HttpAuthorizationFailed, JSONRPCParseError:
+		return CategoryProtocol
+	case MetalinkParsingFailed, BencodedFileParseError, TorrentFileCorrupt, MagnetURIBad, BadOption:
+		return CategoryInput
+	case PieceLengthMismatch, ChecksumValidationFailed:
+		return CategoryIntegrity
+	default:
+		return CategoryUnknown
+	}
+}
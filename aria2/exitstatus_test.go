@@ -0,0 +1,89 @@
+package aria2
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExitStatusCodes(t *testing.T) {
+	// The exit statuses documented by aria2
+	codes := map[ExitStatus]uint8{
+		Success:                        0,
+		UnknownError:                   1,
+		Timeout:                        2,
+		ResourceNotFound:               3,
+		ResourceNotFoundReached:        4,
+		DownloadSpeedTooSlow:           5,
+		NetworkError:                   6,
+		UnfinishedDownloads:            7,
+		RemoteNoResume:                 8,
+		NotEnoughDiskSpace:             9,
+		PieceLengthMismatch:            10,
+		SameFileBeingDownloaded:        11,
+		SameInfoHashBeingDownloaded:    12,
+		FileAlreadyExists:              13,
+		RenamingFailed:                 14,
+		CouldNotOpenExistingFile:       15,
+		CouldNotCreateNewFile:          16,
+		FileIOError:                    17,
+		CouldNotCreateDirectory:        18,
+		NameResolutionFailed:           19,
+		MetalinkParsingFailed:          20,
+		FTPCommandFailed:               21,
+		HTTPResponseHeaderBad:          22,
+		TooManyRedirects:               23,
+		HttpAuthorizationFailed:        24,
+		BencodedFileParseError:         25,
+		TorrentFileCorrupt:             26,
+		MagnetURIBad:                   27,
+		BadOption:                      28,
+		RemoteServerHandleRequestError: 29,
+		JSONRPCParseError:              30,
+		Reserved:                       31,
+		ChecksumValidationFailed:       32,
+	}
+
+	for status, code := range codes {
+		assert.Equal(t, code, uint8(status), status.Description())
+	}
+}
+
+func TestExitStatusTemporary(t *testing.T) {
+	for code, temporary := range map[uint8]bool{
+		2:  true,
+		3:  false,
+		6:  true,
+		19: true,
+		28: false,
+		29: true,
+		32: false,
+	} {
+		assert.Equal(t, temporary, ExitStatus(code).Temporary(), "exit status %d", code)
+	}
+}
+
+func TestExitStatusDescription(t *testing.T) {
+	tests := []struct {
+		code        uint8
+		description string
+		category    ErrorCategory
+	}{
+		{2, "time out occurred", CategoryNetwork},
+		{3, "a resource was not found", CategoryResource},
+		{9, "there was not enough disk space available", CategoryFilesystem},
+		{24, "HTTP authorization failed", CategoryProtocol},
+		{27, "magnet URI was bad", CategoryInput},
+		{28, "an option was bad or unrecognized", CategoryInput},
+		{29, "remote server was unable to handle the request", CategoryNetwork},
+		{30, "could not parse JSON-RPC request", CategoryProtocol},
+		{31, "reserved", CategoryUnknown},
+		{32, "checksum validation failed", CategoryIntegrity},
+		{33, "unknown exit status 33", CategoryUnknown},
+	}
+
+	for _, test := range tests {
+		status := ExitStatus(test.code)
+		assert.Equal(t, test.description, status.Description(), "exit status %d", test.code)
+		assert.Equal(t, test.category, status.Category(), "exit status %d", test.code)
+	}
+}
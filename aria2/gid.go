@@ -1,5 +1,7 @@
 package aria2
 
+import "context"
+
 // GID provides an object oriented approach to aria2.
 // Instead of calling the methods on the client directly,
 // you can call them on the GID instance.
@@ -22,6 +24,13 @@ func (gid *GID) WaitForDownload() error {
 	return gid.client.WaitForDownload(gid.GID)
 }
 
+// WaitForDownloadWithContext waits for the download to finish.
+// The passed context can be used to cancel the download.
+// It returns the status of the finished download.
+func (gid *GID) WaitForDownloadWithContext(ctx context.Context) (Status, error) {
+	return gid.client.WaitForDownloadWithContext(ctx, gid.GID)
+}
+
 // Remove removes the download.
 // If the specified download is in progress, it is first stopped.
 // The status of the removed download becomes removed.
@@ -78,3 +87,41 @@ func (gid *GID) GetURIs() ([]URI, error) {
 func (gid *GID) GetFiles() ([]File, error) {
 	return gid.client.GetFiles(gid.GID)
 }
+
+// ChangeOption changes the options of the download dynamically.
+// Only the options which are set are changed.
+func (gid *GID) ChangeOption(options *Options) error {
+	return gid.client.ChangeOption(gid.GID, options)
+}
+
+// ChangePosition changes the position of the download in the queue.
+// See Client.ChangePosition() for details.
+func (gid *GID) ChangePosition(pos int, how PositionSetBehaviour) (int, error) {
+	return gid.client.ChangePosition(gid.GID, pos, how)
+}
+
+// ChangeURI removes and adds URIs of the file denoted by fileIndex.
+// See Client.ChangeURI() for details.
+func (gid *GID) ChangeURI(fileIndex int, delURIs []string, addURIs []string, position int) (int, int, error) {
+	return gid.client.ChangeURI(gid.GID, fileIndex, delURIs, addURIs, position)
+}
+
+// GetPeers returns the peers of the BitTorrent download.
+func (gid *GID) GetPeers() ([]Peer, error) {
+	return gid.client.GetPeers(gid.GID)
+}
+
+// GetServers returns the servers currently connected to for the HTTP(S)/FTP/SFTP download.
+func (gid *GID) GetServers() ([]FileServers, error) {
+	return gid.client.GetServers(gid.GID)
+}
+
+// GetOption returns the options of the download.
+func (gid *GID) GetOption() (Options, error) {
+	return gid.client.GetOption(gid.GID)
+}
+
+// RemoveDownloadResult removes the completed/error/removed download from memory.
+func (gid *GID) RemoveDownloadResult() error {
+	return gid.client.RemoveDownloadResult(gid.GID)
+}
@@ -0,0 +1,14 @@
+package aria2
+
+// Peer is a BitTorrent peer of a download.
+type Peer struct {
+	PeerId        string
+	IP            string
+	Port          uint `json:",string"`
+	BitField      string
+	AmChoking     bool `json:",string"`
+	PeerChoking   bool `json:",string"`
+	DownloadSpeed uint `json:",string"`
+	UploadSpeed   uint `json:",string"`
+	Seeder        bool `json:",string"`
+}
@@ -0,0 +1,124 @@
+package aria2
+
+import (
+	"log"
+	"time"
+)
+
+// defaultPollInterval is the interval at which the HTTP transport polls for changes by default.
+const defaultPollInterval = time.Second
+
+// maxPolledDownloads is the maximum amount of waiting and stopped downloads checked per poll.
+// It matches the default of the --max-download-result option of aria2.
+const maxPolledDownloads = 1000
+
+// pollStatusKeys are the keys of Status required to emulate the notifications.
+var pollStatusKeys = []string{"gid", "status", "seeder"}
+
+// poll emulates the notifications of aria2 by periodically comparing the statuses of all downloads.
+func (c *Client) poll() {
+	var known map[string]Status
+
+	ticker := time.NewTicker(c.options.PollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if c.isClosed() {
+			return
+		}
+
+		statuses, err := c.pollStatuses()
+		if err != nil {
+			log.Printf("aria2: polling %s failed: %s\n", c.url, err)
+			continue
+		}
+
+		// Without a previous state there is nothing to compare to,
+		// but downloads which are waited for might have finished already
+		if known == nil {
+			c.reconcile()
+		} else {
+			for gid, status := range statuses {
+				previous, ok := known[gid]
+				for _, eventType := range statusEvents(previous, ok, status) {
+					c.onEvent(eventType, &DownloadEvent{GID: gid})
+				}
+			}
+
+			// Downloads disappear without an event if another client removes their result
+			// or aria2 discards it because of --max-download-result
+			var missing []string
+			for _, gid := range c.waited() {
+				if _, ok := statuses[gid]; !ok {
+					missing = append(missing, gid)
+				}
+			}
+			c.check(missing)
+		}
+
+		known = statuses
+	}
+}
+
+// pollStatuses returns the statuses of all active, waiting and stopped downloads by their GIDs.
+func (c *Client) pollStatuses() (map[string]Status, error) {
+	var active, waiting, stopped []Status
+
+	batch := c.Batch()
+	calls := []*BatchCall{
+		batch.Call("aria2.tellActive", []interface{}{pollStatusKeys}, &active),
+		batch.Call("aria2.tellWaiting", []interface{}{0, maxPolledDownloads, pollStatusKeys}, &waiting),
+		batch.Call("aria2.tellStopped", []interface{}{0, maxPolledDownloads, pollStatusKeys}, &stopped),
+	}
+
+	if err := batch.Send(); err != nil {
+		return nil, err
+	}
+
+	for _, call := range calls {
+		if call.Err != nil {
+			return nil, call.Err
+		}
+	}
+
+	statuses := make(map[string]Status, len(active)+len(waiting)+len(stopped))
+	for _, list := range [][]Status{active, waiting, stopped} {
+		for _, status := range list {
+			statuses[status.GID] = status
+		}
+	}
+
+	return statuses, nil
+}
+
+// statusEvents returns the events aria2 would have sent for the change from previous to current.
+// known is false if the download didn't exist before.
+func statusEvents(previous Status, known bool, current Status) []EventType {
+	if known && previous.Status == current.Status && previous.Seeder == current.Seeder {
+		return nil
+	}
+
+	switch current.Status {
+	case StatusActive:
+		var events []EventType
+		if !known || previous.Status != StatusActive {
+			events = append(events, EventDownloadStart)
+		}
+
+		if current.Seeder && (!known || !previous.Seeder) {
+			events = append(events, EventBtDownloadComplete)
+		}
+
+		return events
+	case StatusPaused:
+		return []EventType{EventDownloadPause}
+	case StatusCompleted:
+		return []EventType{EventDownloadComplete}
+	case StatusError:
+		return []EventType{EventDownloadError}
+	case StatusRemoved:
+		return []EventType{EventDownloadStop}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package aria2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusEvents(t *testing.T) {
+	active := Status{Status: StatusActive}
+	seeding := Status{Status: StatusActive, Seeder: true}
+
+	assert.Nil(t, statusEvents(active, true, active))
+	assert.Equal(t, []EventType{EventDownloadStart}, statusEvents(Status{}, false, active))
+	assert.Equal(t, []EventType{EventDownloadStart}, statusEvents(Status{Status: StatusWaiting}, true, active))
+	assert.Equal(t, []EventType{EventBtDownloadComplete}, statusEvents(active, true, seeding))
+	assert.Equal(t, []EventType{EventDownloadPause}, statusEvents(active, true, Status{Status: StatusPaused}))
+	assert.Equal(t, []EventType{EventDownloadComplete}, statusEvents(active, true, Status{Status: StatusCompleted}))
+	assert.Equal(t, []EventType{EventDownloadError}, statusEvents(Status{}, false, Status{Status: StatusError}))
+	assert.Equal(t, []EventType{EventDownloadStop}, statusEvents(active, true, Status{Status: StatusRemoved}))
+	assert.Nil(t, statusEvents(Status{}, false, Status{Status: StatusWaiting}))
+}
+
+func TestPollResolvesMissingDownloads(t *testing.T) {
+	var mu sync.Mutex
+	statuses := map[string]Status{"2089b05ecca3d829": {GID: "2089b05ecca3d829", Status: StatusActive}}
+	unavailable := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Id     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := map[string]interface{}{"id": req.Id, "jsonrpc": "2.0"}
+		switch req.Method {
+		case "system.multicall":
+			// tellActive, tellWaiting and tellStopped
+			active := make([]Status, 0, len(statuses))
+			for _, status := range statuses {
+				active = append(active, status)
+			}
+			res["result"] = []interface{}{[]interface{}{active}, []interface{}{[]Status{}}, []interface{}{[]Status{}}}
+		case "aria2.tellStatus":
+			var gid string
+			_ = json.Unmarshal(req.Params[0], &gid)
+			if unavailable {
+				res["error"] = map[string]interface{}{"code": 1, "message": "unavailable"}
+			} else if status, ok := statuses[gid]; ok {
+				res["result"] = status
+			} else {
+				res["error"] = map[string]interface{}{"code": 1, "message": fmt.Sprintf("GID %s is not found", gid)}
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+
+	client, err := Dial(srv.URL, WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.WaitForDownloadWithContext(context.Background(), "2089b05ecca3d829")
+		result <- err
+	}()
+
+	waitFor(t, func() bool {
+		client.mu.RLock()
+		defer client.mu.RUnlock()
+		return len(client.waiters) == 1
+	})
+	// Give the poll a chance to see the download before it disappears
+	time.Sleep(50 * time.Millisecond)
+
+	// Another client removes the download, but it can't be checked yet
+	mu.Lock()
+	delete(statuses, "2089b05ecca3d829")
+	unavailable = true
+	mu.Unlock()
+
+	select {
+	case err := <-result:
+		t.Fatalf("a download which couldn't be checked mustn't be lost, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	mu.Lock()
+	unavailable = false
+	mu.Unlock()
+
+	select {
+	case err := <-result:
+		var lostErr *LostError
+		assert.True(t, errors.As(err, &lostErr), "expected the download to be lost, got %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting for a download which disappeared didn't end")
+	}
+}
@@ -0,0 +1,420 @@
+package aria2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// processStartAttempts is the amount of ports tried when starting aria2.
+const processStartAttempts = 3
+
+// The timings of the process are variables so that the tests can shorten them.
+var (
+	// processStartTimeout is the time aria2 has to start accepting connections.
+	processStartTimeout = 10 * time.Second
+	// processStopTimeout is the time aria2 has to shut down before it's killed.
+	processStopTimeout = 30 * time.Second
+	// processRestartDelay is the delay before restarting a crashed aria2 process.
+	processRestartDelay = 5 * time.Second
+)
+
+// errProcessStopping is returned when starting a process which is being stopped.
+var errProcessStopping = errors.New("aria2 is being stopped")
+
+// ProcessOptions configure an aria2 process started by StartProcess.
+type ProcessOptions struct {
+	// Path is the path of the aria2c executable, it's looked up in PATH if it doesn't contain a slash.
+	// Defaults to "aria2c".
+	Path string
+	// WorkDir contains the generated config and the session file, it's created if it doesn't exist.
+	// It's required, the session is restored from it when the process is started again.
+	WorkDir string
+	// Dir is the directory the files are downloaded to.
+	Dir string
+	// Options are additional aria2 options like "max-concurrent-downloads".
+	Options map[string]string
+	// Logger receives the output of aria2, the standard logger is used if it's nil.
+	Logger *log.Logger
+}
+
+// Process is an aria2c process managed by arias.
+// It's restarted when it crashes until Stop is called.
+type Process struct {
+	options    ProcessOptions
+	port       int
+	secret     string
+	configPath string
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+	exited   chan struct{}
+}
+
+// StartProcess starts a new aria2c process listening on a free port of the loopback interface.
+// A random secret is generated for the rpc interface.
+// It returns once the rpc interface accepts connections.
+func StartProcess(options ProcessOptions) (p *Process, err error) {
+	if options.Path == "" {
+		options.Path = "aria2c"
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.Default()
+	}
+
+	if options.WorkDir == "" {
+		return nil, errors.New("aria2 work dir must be specified")
+	}
+
+	if err = os.MkdirAll(options.WorkDir, 0700); err != nil {
+		return
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return
+	}
+
+	p = &Process{options: options, secret: secret}
+	for attempt := 1; ; attempt++ {
+		if p.port, err = freePort(); err != nil {
+			return nil, err
+		}
+
+		// The port might be taken by someone else before aria2 binds it
+		if err = p.run(); err == nil {
+			break
+		} else if attempt == processStartAttempts {
+			return nil, err
+		}
+
+		p.options.Logger.Printf("aria2: starting on port %d failed, trying another port: %s\n", p.port, err)
+	}
+
+	go p.supervise()
+	return
+}
+
+// Addr returns the websocket url of the rpc interface.
+func (p *Process) Addr() string {
+	return fmt.Sprintf("ws://127.0.0.1:%d/jsonrpc", p.port)
+}
+
+// Secret returns the secret of the rpc interface.
+func (p *Process) Secret() string {
+	return p.secret
+}
+
+// checkPort checks whether the port can be listened on.
+func checkPort(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+
+	return l.Close()
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = l.Close() }()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// sessionPath is the path of the file the downloads are saved to.
+func (p *Process) sessionPath() string {
+	return filepath.Join(p.options.WorkDir, "aria2.session")
+}
+
+// writeConfig generates the config file of aria2.
+// The session is saved periodically so that downloads survive a crash.
+func (p *Process) writeConfig() error {
+	options := map[string]string{
+		"continue":              "true",
+		"save-session-interval": "30",
+	}
+	for key, value := range p.options.Options {
+		options[key] = value
+	}
+
+	// These are required to communicate with the process and can't be overridden
+	options["enable-rpc"] = "true"
+	options["rpc-listen-all"] = "false"
+	options["rpc-listen-port"] = strconv.Itoa(p.port)
+	options["rpc-secret"] = p.secret
+	options["save-session"] = p.sessionPath()
+	options["daemon"] = "false"
+	if p.options.Dir != "" {
+		options["dir"] = p.options.Dir
+	}
+
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var conf strings.Builder
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(&conf, "%s=%s\n", key, options[key])
+	}
+
+	p.configPath = filepath.Join(p.options.WorkDir, "aria2.conf")
+	return ioutil.WriteFile(p.configPath, []byte(conf.String()), 0600)
+}
+
+// run writes the config and starts the process.
+// It returns once the process is ready, if it doesn't get ready, it's killed.
+func (p *Process) run() error {
+	if err := p.writeConfig(); err != nil {
+		return err
+	}
+
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	if err := p.waitUntilReady(); err != nil {
+		_ = p.kill()
+		return err
+	}
+
+	return nil
+}
+
+// start starts the process, unless it's being stopped.
+func (p *Process) start() error {
+	args := []string{"--conf-path=" + p.configPath}
+	// The session only exists once aria2 saved it
+	if _, err := os.Stat(p.sessionPath()); err == nil {
+		args = append(args, "--input-file="+p.sessionPath())
+	}
+
+	cmd := exec.Command(p.options.Path, args...)
+	output := &logWriter{logger: p.options.Logger, prefix: "aria2: "}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	// Stop mustn't miss a process started concurrently
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopping {
+		return errProcessStopping
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	p.cmd = cmd
+	p.exited = exited
+
+	go func() {
+		_ = cmd.Wait()
+		output.flush()
+		close(exited)
+	}()
+
+	return nil
+}
+
+func (p *Process) isStopping() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stopping
+}
+
+// supervise restarts the process whenever it exits without being stopped.
+func (p *Process) supervise() {
+	for {
+		p.mu.Lock()
+		cmd, exited := p.cmd, p.exited
+		p.mu.Unlock()
+
+		<-exited
+		if p.isStopping() {
+			return
+		}
+
+		p.options.Logger.Printf("aria2: process exited unexpectedly (%s), restarting in %s\n", cmd.ProcessState, processRestartDelay)
+		for {
+			time.Sleep(processRestartDelay)
+			if p.isStopping() {
+				return
+			}
+
+			err := p.restart()
+			if err == nil {
+				break
+			} else if err == errProcessStopping {
+				return
+			}
+
+			p.options.Logger.Printf("aria2: restarting failed, retrying in %s: %s\n", processRestartDelay, err)
+		}
+	}
+}
+
+// restart starts the process on its previous port, which is where the clients reconnect to.
+func (p *Process) restart() error {
+	// Someone else could have taken the port while aria2 wasn't running
+	if err := checkPort(p.port); err != nil {
+		return err
+	}
+
+	return p.run()
+}
+
+// ping calls the rpc interface using the secret,
+// which ensures that it's served by this process and not by whatever else might listen on the port.
+func (p *Process) ping() error {
+	t := newHTTPTransport(fmt.Sprintf("http://127.0.0.1:%d/jsonrpc", p.port), &http.Client{Timeout: time.Second}, http.Header{})
+
+	var version VersionInfo
+	return t.Call("aria2.getVersion", []interface{}{"token:" + p.secret}, &version)
+}
+
+// waitUntilReady waits for the rpc interface to accept calls.
+func (p *Process) waitUntilReady() error {
+	p.mu.Lock()
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	deadline := time.Now().Add(processStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			return fmt.Errorf("aria2 exited during startup (%s)", cmd.ProcessState)
+		default:
+		}
+
+		if p.ping() == nil {
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return errors.New("aria2 didn't start in time")
+}
+
+// Stop saves the session and shuts down the process using the given client.
+// If the shutdown fails, the process is asked to terminate using a signal instead.
+// If it doesn't exit in time, it's killed.
+func (p *Process) Stop(client *Client) error {
+	p.mu.Lock()
+	p.stopping = true
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	select {
+	case <-exited:
+		return nil
+	default:
+	}
+
+	shutDown := false
+	if client != nil {
+		if err := client.SaveSession(); err != nil {
+			p.options.Logger.Printf("aria2: couldn't save session: %s\n", err)
+		}
+
+		if err := client.Shutdown(); err != nil {
+			p.options.Logger.Printf("aria2: couldn't shut down, terminating the process instead: %s\n", err)
+		} else {
+			shutDown = true
+		}
+	}
+
+	// aria2 also saves the session when it's terminated
+	if !shutDown {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			return p.kill()
+		}
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(processStopTimeout):
+		p.options.Logger.Printf("aria2: process didn't exit within %s, killing it\n", processStopTimeout)
+		return p.kill()
+	}
+}
+
+// kill kills the process and waits until it exited.
+func (p *Process) kill() error {
+	p.mu.Lock()
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	<-exited
+	return nil
+}
+
+// logWriter logs the output of a process line by line.
+type logWriter struct {
+	logger *log.Logger
+	prefix string
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.logger.Print(w.prefix + string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// flush logs the last line if it wasn't terminated.
+func (w *logWriter) flush() {
+	if len(w.buf) > 0 {
+		w.logger.Print(w.prefix + string(w.buf))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,302 @@
+package aria2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeProcessEnv makes the test binary act as aria2c.
+const fakeProcessEnv = "ARIA2_FAKE_PROCESS"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeProcessEnv) != "" {
+		os.Exit(fakeAria2c(os.Args[1:]))
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeAria2c serves the rpc interface configured by the --conf-path argument like aria2c does
+// and reports the session passed by --input-file. Its behaviour is changed by the options fake-crash=once, which exits shortly after the first start,
+// fake-shutdown=fail, which rejects aria2.shutdown, and fake-term=ignore, which ignores SIGTERM.
+func fakeAria2c(args []string) int {
+	var confPath string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--conf-path=") {
+			confPath = strings.TrimPrefix(arg, "--conf-path=")
+		} else if strings.HasPrefix(arg, "--input-file=") {
+			fmt.Println("fake aria2 restoring " + strings.TrimPrefix(arg, "--input-file="))
+		}
+	}
+
+	data, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	conf := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '='); i > 0 {
+			conf[line[:i]] = line[i+1:]
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:"+conf["rpc-listen-port"])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	terminate := make(chan os.Signal, 1)
+	if conf["fake-term"] == "ignore" {
+		signal.Ignore(syscall.SIGTERM)
+	} else {
+		signal.Notify(terminate, syscall.SIGTERM)
+	}
+
+	exit := make(chan int, 1)
+	if conf["fake-crash"] == "once" {
+		marker := filepath.Join(filepath.Dir(confPath), "crashed")
+		if _, err := os.Stat(marker); os.IsNotExist(err) {
+			_ = ioutil.WriteFile(marker, nil, 0600)
+			time.AfterFunc(200*time.Millisecond, func() { exit <- 1 })
+		}
+	}
+
+	go func() {
+		_ = http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Id     uint64            `json:"id"`
+				Method string            `json:"method"`
+				Params []json.RawMessage `json:"params"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+
+			var token string
+			if len(req.Params) > 0 {
+				_ = json.Unmarshal(req.Params[0], &token)
+			}
+
+			res := map[string]interface{}{"id": req.Id, "jsonrpc": "2.0"}
+			switch {
+			case token != "token:"+conf["rpc-secret"]:
+				res["error"] = map[string]interface{}{"code": 1, "message": "Unauthorized"}
+			case req.Method == "aria2.shutdown" && conf["fake-shutdown"] == "fail":
+				res["error"] = map[string]interface{}{"code": 1, "message": "shutdown failed"}
+			case req.Method == "aria2.shutdown":
+				res["result"] = "OK"
+				time.AfterFunc(50*time.Millisecond, func() { exit <- 0 })
+			case req.Method == "aria2.getVersion":
+				res["result"] = VersionInfo{Version: "1.35.0"}
+			default:
+				res["result"] = "OK"
+			}
+
+			_ = json.NewEncoder(w).Encode(res)
+		}))
+	}()
+
+	fmt.Println("fake aria2 started")
+
+	select {
+	case code := <-exit:
+		return code
+	case <-terminate:
+		return 0
+	}
+}
+
+// syncBuffer is a bytes.Buffer which can be used concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// startFakeProcess starts the test binary as aria2c and returns the process and its output.
+func startFakeProcess(t *testing.T, options map[string]string) (*Process, *syncBuffer) {
+	t.Setenv(fakeProcessEnv, "1")
+
+	output := &syncBuffer{}
+	p, err := StartProcess(ProcessOptions{
+		Path:    os.Args[0],
+		WorkDir: t.TempDir(),
+		Options: options,
+		Logger:  log.New(output, "", 0),
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		p.mu.Lock()
+		p.stopping = true
+		p.mu.Unlock()
+		_ = p.kill()
+	})
+
+	return p, output
+}
+
+// processClient connects to the process using the HTTP transport.
+func processClient(t *testing.T, p *Process) *Client {
+	client, err := Dial(fmt.Sprintf("http://127.0.0.1:%d/jsonrpc", p.port), WithSecret(p.Secret()), WithPollInterval(time.Hour))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+// setDuration changes the duration for the duration of the test.
+func setDuration(t *testing.T, d *time.Duration, value time.Duration) {
+	previous := *d
+	*d = value
+	t.Cleanup(func() { *d = previous })
+}
+
+func TestProcessStartStop(t *testing.T) {
+	p, output := startFakeProcess(t, nil)
+	assert.NoError(t, p.ping())
+	assert.Contains(t, output.String(), "aria2: fake aria2 started\n")
+
+	assert.NoError(t, p.Stop(processClient(t, p)))
+	assert.True(t, p.cmd.ProcessState.Success())
+	assert.Error(t, p.ping())
+}
+
+func TestProcessStopTerminates(t *testing.T) {
+	p, _ := startFakeProcess(t, map[string]string{"fake-shutdown": "fail"})
+
+	assert.NoError(t, p.Stop(processClient(t, p)))
+	assert.True(t, p.cmd.ProcessState.Success(), "the process was killed instead of terminated")
+}
+
+func TestProcessStopKills(t *testing.T) {
+	setDuration(t, &processStopTimeout, 200*time.Millisecond)
+	p, _ := startFakeProcess(t, map[string]string{"fake-shutdown": "fail", "fake-term": "ignore"})
+
+	start := time.Now()
+	assert.NoError(t, p.Stop(processClient(t, p)))
+	assert.True(t, time.Since(start) >= processStopTimeout, "the process was killed before the grace period ended")
+	assert.False(t, p.cmd.ProcessState.Success())
+}
+
+func TestProcessPortTaken(t *testing.T) {
+	t.Setenv(fakeProcessEnv, "1")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+
+	// The port accepts connections, but it isn't aria2
+	p := &Process{
+		options: ProcessOptions{Path: os.Args[0], WorkDir: t.TempDir(), Logger: log.New(ioutil.Discard, "", 0)},
+		port:    l.Addr().(*net.TCPAddr).Port,
+		secret:  "secret",
+	}
+
+	err = p.run()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exited during startup")
+	}
+}
+
+func TestProcessRestart(t *testing.T) {
+	setDuration(t, &processRestartDelay, 200*time.Millisecond)
+	p, output := startFakeProcess(t, map[string]string{"fake-crash": "once"})
+
+	p.mu.Lock()
+	exited := p.exited
+	p.mu.Unlock()
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the process didn't crash")
+	}
+
+	// The port is taken while the process is down, the restart waits for it to become free again
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p.port))
+	require.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+	_ = l.Close()
+
+	waitFor(t, func() bool { return p.ping() == nil })
+	assert.Equal(t, 2, strings.Count(output.String(), "fake aria2 started"))
+
+	assert.NoError(t, p.Stop(processClient(t, p)))
+	time.Sleep(2 * processRestartDelay)
+	assert.Error(t, p.ping(), "a stopped process mustn't be restarted")
+}
+
+func TestProcessWorkDir(t *testing.T) {
+	t.Setenv(fakeProcessEnv, "1")
+
+	_, err := StartProcess(ProcessOptions{Path: os.Args[0]})
+	assert.Error(t, err, "the session mustn't be lost in a temporary directory")
+
+	workDir := filepath.Join(t.TempDir(), "aria2")
+	output := &syncBuffer{}
+	p, err := StartProcess(ProcessOptions{Path: os.Args[0], WorkDir: workDir, Logger: log.New(output, "", 0)})
+	require.NoError(t, err)
+	require.NoError(t, p.Stop(processClient(t, p)))
+	assert.DirExists(t, workDir)
+
+	// The session saved by the previous process is restored
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workDir, "aria2.session"), nil, 0600))
+	p, err = StartProcess(ProcessOptions{Path: os.Args[0], WorkDir: workDir, Logger: log.New(output, "", 0)})
+	require.NoError(t, err)
+	require.NoError(t, p.Stop(processClient(t, p)))
+	assert.Contains(t, output.String(), "fake aria2 restoring "+filepath.Join(workDir, "aria2.session"))
+}
+
+func TestProcessConfig(t *testing.T) {
+	p := &Process{
+		options: ProcessOptions{
+			WorkDir: t.TempDir(),
+			Dir:     "/downloads",
+			Options: map[string]string{"max-concurrent-downloads": "5", "rpc-secret": "ignored"},
+		},
+		port:   6801,
+		secret: "secret",
+	}
+
+	if !assert.NoError(t, p.writeConfig()) {
+		return
+	}
+
+	conf, err := ioutil.ReadFile(p.configPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(conf), "max-concurrent-downloads=5\n")
+	assert.Contains(t, string(conf), "rpc-listen-port=6801\n")
+	assert.Contains(t, string(conf), "rpc-secret=secret\n")
+	assert.Contains(t, string(conf), "dir=/downloads\n")
+	assert.NotContains(t, string(conf), "ignored")
+}
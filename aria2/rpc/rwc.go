@@ -1,8 +1,11 @@
 package rpc
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"io"
+	"io/ioutil"
 )
 
 type ReadWriteCloser struct {
@@ -15,12 +18,41 @@ func NewReadWriteCloser(ws *websocket.Conn) ReadWriteCloser {
 	return ReadWriteCloser{ws: ws}
 }
 
+// stringifyError encodes the error object of a response as a JSON string.
+// The jsonrpc codec only accepts string errors and closes the connection otherwise.
+func stringifyError(data []byte) []byte {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return data
+	}
+
+	rawErr, ok := msg["error"]
+	if !ok || !bytes.HasPrefix(bytes.TrimSpace(rawErr), []byte("{")) {
+		return data
+	}
+
+	msg["error"], _ = json.Marshal(string(rawErr))
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return data
+	}
+
+	return out
+}
+
 func (rwc *ReadWriteCloser) Read(p []byte) (n int, err error) {
 	if rwc.r == nil {
-		_, rwc.r, err = rwc.ws.NextReader()
-		if err != nil {
+		var r io.Reader
+		if _, r, err = rwc.ws.NextReader(); err != nil {
+			return 0, err
+		}
+
+		var data []byte
+		if data, err = ioutil.ReadAll(r); err != nil {
 			return 0, err
 		}
+
+		rwc.r = bytes.NewReader(stringifyError(data))
 	}
 
 	for n = 0; n < len(p); {
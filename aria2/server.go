@@ -0,0 +1,17 @@
+package aria2
+
+// FileServers contains the servers a file of a download is currently downloaded from.
+type FileServers struct {
+	Index   int `json:",string"`
+	Servers []Server
+}
+
+// Server is a HTTP(S)/FTP/SFTP server connected to by aria2.
+type Server struct {
+	// URI is the original URI
+	URI string
+	// CurrentURI is the URI currently used for downloading,
+	// it differs from URI if redirection is involved.
+	CurrentURI    string
+	DownloadSpeed uint `json:",string"`
+}
@@ -0,0 +1,26 @@
+package aria2
+
+// GlobalStat contains the overall download and upload speeds and the number of downloads.
+type GlobalStat struct {
+	DownloadSpeed uint `json:",string"`
+	UploadSpeed   uint `json:",string"`
+	NumActive     uint `json:",string"`
+	NumWaiting    uint `json:",string"`
+	// NumStopped is the number of stopped downloads in the current session,
+	// capped by the --max-download-result option.
+	NumStopped uint `json:",string"`
+	// NumStoppedTotal is the number of stopped downloads in the current session,
+	// not capped by the --max-download-result option.
+	NumStoppedTotal uint `json:",string"`
+}
+
+// VersionInfo contains the version of aria2 and the features it was built with.
+type VersionInfo struct {
+	Version         string
+	EnabledFeatures []string
+}
+
+// SessionInfo contains the id of the aria2 session, it's generated every time aria2 is started.
+type SessionInfo struct {
+	SessionId string
+}
@@ -12,7 +12,7 @@ const (
 	StatusWaiting   StatusName = "waiting"
 	StatusPaused    StatusName = "paused"
 	StatusError     StatusName = "error"
-	StatusCompleted StatusName = "completed"
+	StatusCompleted StatusName = "complete"
 	StatusRemoved   StatusName = "removed"
 )
 
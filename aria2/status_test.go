@@ -57,3 +57,22 @@ func TestFormat(t *testing.T) {
 	assert.EqualValues(t, true, file1.Selected)
 	assert.Equal(t, []URI{{Status: URIUsed, URI: "http://example.org/file"}}, file1.URIs)
 }
+
+func TestStatusNames(t *testing.T) {
+	var status Status
+	err := json.Unmarshal([]byte(`{"gid": "2089b05ecca3d829", "status": "complete"}`), &status)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, status.Status)
+}
+
+func TestOptionsHeader(t *testing.T) {
+	var options Options
+	err := json.Unmarshal([]byte(`{"header": "A: 1\nB: 2", "split": "5", "continue": "true"}`), &options)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A: 1", "B: 2"}, options.Header)
+	assert.True(t, options.Continue)
+
+	err = json.Unmarshal([]byte(`{"header": ["A: 1"]}`), &options)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A: 1"}, options.Header)
+}
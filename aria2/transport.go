@@ -0,0 +1,120 @@
+package aria2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cenkalti/rpc2"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync/atomic"
+)
+
+// transport sends calls to the aria2 rpc interface.
+type transport interface {
+	Call(method string, params []interface{}, reply interface{}) error
+	Close() error
+}
+
+// wsTransport sends calls over a websocket connection.
+type wsTransport struct {
+	ws        *websocket.Conn
+	rpcClient *rpc2.Client
+}
+
+func (t *wsTransport) Call(method string, params []interface{}, reply interface{}) error {
+	err := t.rpcClient.Call(method, params, reply)
+
+	// the error object was passed through as a string by the ReadWriteCloser
+	if serverErr, ok := err.(rpc2.ServerError); ok {
+		var fault Fault
+		if json.Unmarshal([]byte(serverErr), &fault) == nil {
+			return &fault
+		}
+	}
+
+	return err
+}
+
+func (t *wsTransport) Close() error {
+	err := t.rpcClient.Close()
+	wsErr := t.ws.Close()
+	if err == nil {
+		err = wsErr
+	}
+
+	return err
+}
+
+// httpTransport sends every call as a separate HTTP POST request.
+type httpTransport struct {
+	url    string
+	client *http.Client
+	header http.Header
+	lastId uint64
+}
+
+func newHTTPTransport(url string, client *http.Client, header http.Header) *httpTransport {
+	return &httpTransport{url: url, client: client, header: header}
+}
+
+type httpRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Id      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type httpResponse struct {
+	Id     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Fault          `json:"error"`
+}
+
+func (t *httpTransport) Call(method string, params []interface{}, reply interface{}) error {
+	id := atomic.AddUint64(&t.lastId, 1)
+	body, err := json.Marshal(httpRequest{JSONRPC: "2.0", Id: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range t.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// aria2 also uses error status codes for rpc errors, which have a body
+	var res httpResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+
+		return err
+	}
+
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if reply == nil {
+		return nil
+	}
+
+	return json.Unmarshal(res.Result, reply)
+}
+
+func (t *httpTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package aria2
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req httpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+
+		if req.Method == "aria2.getVersion" {
+			_, _ = w.Write([]byte(`{"id": 1, "jsonrpc": "2.0", "result": {"version": "1.35.0", "enabledFeatures": ["BitTorrent"]}}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"id": 2, "jsonrpc": "2.0", "error": {"code": 1, "message": "Unauthorized"}}`))
+	}))
+	defer srv.Close()
+
+	client, err := Dial(srv.URL, WithHeader(http.Header{"Authorization": {"Bearer abc"}}), WithSecret("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	version, err := client.GetVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, VersionInfo{Version: "1.35.0", EnabledFeatures: []string{"BitTorrent"}}, version)
+
+	err = client.Pause("2089b05ecca3d829")
+	assert.Equal(t, &Fault{Code: 1, Message: "Unauthorized"}, err)
+}
+
+func TestHTTPTransportClient(t *testing.T) {
+	client, err := Dial("http://127.0.0.1:6800/jsonrpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	// Without a timeout, calls to an unreachable server could block forever
+	assert.Equal(t, 30*time.Second, client.transport.(*httpTransport).client.Timeout)
+
+	httpClient := &http.Client{Timeout: time.Second}
+	client, err = Dial("http://127.0.0.1:6800/jsonrpc", WithHTTPClient(httpClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	assert.Same(t, httpClient, client.transport.(*httpTransport).client)
+}
@@ -8,6 +8,6 @@ const (
 )
 
 type URI struct {
-	URI    string
-	Status URIStatus
+	URI    string    `json:"uri"`
+	Status URIStatus `json:"status"`
 }
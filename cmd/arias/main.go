@@ -4,6 +4,9 @@ import (
 	"flag"
 	"github.com/MyAnimeStream/arias"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
@@ -24,5 +27,15 @@ func main() {
 		log.Fatal("Couldn't start server: ", err)
 	}
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := server.Close(); err != nil {
+			log.Print("Error shutting down: ", err)
+		}
+		os.Exit(0)
+	}()
+
 	log.Fatal(server.ListenAndServe())
 }
@@ -1,32 +1,172 @@
 package arias
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/micro/go-config"
 	"github.com/micro/go-config/source"
 	"github.com/micro/go-config/source/env"
 	"github.com/micro/go-config/source/file"
+	"time"
 )
 
+// Duration is a time.Duration which can be configured
+// either as a string like "1m30s" or as a number of seconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// Aria2Backend is an aria2 instance downloads can be placed on.
+// The files it downloads have to be accessible to arias at the same path.
+type Aria2Backend struct {
+	Name   string
+	Addr   string
+	Secret string
+}
+
+// StorageTarget is a named storage the files of a download can be uploaded to.
+type StorageTarget struct {
+	Name string
+	// Type is one of "google", "s3", "local", "sftp", "ftp" or "webdav"
+	Type string
+
+	// DefaultBucket is used if the request doesn't specify a bucket
+	DefaultBucket string
+	// Buckets a request may upload to in addition to the default bucket, "*" allows any bucket
+	Buckets []string
+
+	// The settings of the storage type
+	Google GoogleStorageConfig
+	S3     S3StorageConfig
+	Local  LocalStorageConfig
+	SFTP   SFTPStorageConfig
+	FTP    FTPStorageConfig
+	WebDAV WebDAVStorageConfig
+}
+
+// AllowsBucket reports whether a request may upload to the given bucket.
+func (t StorageTarget) AllowsBucket(bucket string) bool {
+	return bucket == t.DefaultBucket || containsString(t.Buckets, "*") || containsString(t.Buckets, bucket)
+}
+
+// defaultStorageName is the name of the storage target created from Config.StorageType
+const defaultStorageName = "default"
+
 type Config struct {
 	ServerAddr string
-	Aria2Addr  string
+	// AllowedOrigins are the origins of the web pages which may open the event websocket, "*" allows any origin.
+	// Pages served by arias itself and clients which don't send an origin are always allowed.
+	AllowedOrigins []string
+	// Aria2Addr is the address of the aria2 rpc interface,
+	// either a websocket (ws://) or a HTTP (http://) url.
+	Aria2Addr string
+	// Aria2Secret is the secret token of the aria2 rpc interface (--rpc-secret)
+	Aria2Secret string
+
+	// Aria2Backends are used instead of Aria2Addr to distribute the downloads across several aria2 instances
+	Aria2Backends []Aria2Backend
 
+	// ManageAria2 makes arias start and supervise its own aria2c process.
+	// Aria2Addr and Aria2Secret are ignored in that case, the process is used in addition to Aria2Backends.
+	ManageAria2 bool
+	// Aria2Path is the path of the aria2c executable
+	Aria2Path string
+	// Aria2WorkDir contains the config and session of the managed process, defaults to aria2.
+	// It's kept across restarts so that the downloads of restored tasks are resumed.
+	Aria2WorkDir string
+	// Aria2Dir is the directory the managed process downloads to
+	Aria2Dir string
+	// Aria2Options are additional options for the managed process
+	Aria2Options map[string]string
+
+	// StorageType, DefaultBucket, AllowBucketOverride and the settings of the storage types below
+	// configure the only storage target if StorageTargets is empty.
 	StorageType string
+	// Google configures the "google" storage type
+	Google GoogleStorageConfig
+	// Local configures the "local" storage type
+	Local LocalStorageConfig
+	// S3 configures the "s3" storage type
+	S3 S3StorageConfig
+	// SFTP configures the "sftp" storage type
+	SFTP SFTPStorageConfig
+	// FTP configures the "ftp" storage type
+	FTP FTPStorageConfig
+	// WebDAV configures the "webdav" storage type
+	WebDAV WebDAVStorageConfig
+
+	// TaskStorePath is the path of the database used to persist tasks across restarts, defaults to arias.db.
+	// If it's set to an empty string, tasks are only kept in memory.
+	TaskStorePath string
+	// TaskRetention is how long finished tasks are kept in the task store, 0 keeps them forever
+	TaskRetention Duration
 
 	DefaultBucket string
 	// AllowBucketOverride specifies whether the requester can override the bucket to upload to
 	AllowBucketOverride bool
 
+	// StorageTargets are used instead of StorageType to upload to several storages,
+	// a request selects one by its name.
+	StorageTargets []StorageTarget
+	// DefaultStorage is the name of the target used if the request doesn't select one, defaults to the first target
+	DefaultStorage string
+
 	// AllowNoName specifies whether the requester may omit the name from the request.
 	// Arias would use the name of the downloaded file in that case
 	AllowNoName bool
+
+	// DownloadOptions are used for the options a request doesn't set
+	DownloadOptions DownloadOptions
+	// MaxSplit and MaxConnectionPerServer limit the options of a request, 0 means no limit
+	MaxSplit               uint
+	MaxConnectionPerServer uint
+
+	// DownloadRetry and UploadRetry specify how often the stages of a task are attempted
+	DownloadRetry RetryPolicy
+	UploadRetry   RetryPolicy
 }
 
 func defaultConfig() Config {
 	return Config{
-		ServerAddr: ":7200",
-		Aria2Addr:  "ws://localhost:6800/jsonrpc",
+		ServerAddr:   ":7200",
+		Aria2Addr:    "ws://localhost:6800/jsonrpc",
+		Aria2Path:    "aria2c",
+		Aria2WorkDir: "aria2",
+
+		TaskStorePath: "arias.db",
+		TaskRetention: Duration{7 * 24 * time.Hour},
+
+		MaxSplit:               16,
+		MaxConnectionPerServer: 16,
+
+		DownloadRetry: defaultRetryPolicy(),
+		UploadRetry:   defaultRetryPolicy(),
 	}
 }
 
@@ -49,13 +189,105 @@ func LoadConfig(configFile string) (c Config, err error) {
 	return
 }
 
+// storageTargets returns the configured storage targets.
+// Without StorageTargets, a single target is created from StorageType.
+func (c *Config) storageTargets() []StorageTarget {
+	if len(c.StorageTargets) > 0 {
+		return c.StorageTargets
+	}
+
+	target := StorageTarget{
+		Name:          defaultStorageName,
+		Type:          c.StorageType,
+		DefaultBucket: c.DefaultBucket,
+
+		Google: c.Google,
+		S3:     c.S3,
+		Local:  c.Local,
+		SFTP:   c.SFTP,
+		FTP:    c.FTP,
+		WebDAV: c.WebDAV,
+	}
+	if c.AllowBucketOverride {
+		target.Buckets = []string{"*"}
+	}
+
+	return []StorageTarget{target}
+}
+
+// storageTarget returns the target with the given name.
+// If name is empty, the default target is returned.
+func (c *Config) storageTarget(name string) (StorageTarget, bool) {
+	targets := c.storageTargets()
+
+	if name == "" {
+		name = c.DefaultStorage
+		if name == "" {
+			return targets[0], true
+		}
+	}
+
+	for _, target := range targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+
+	return StorageTarget{}, false
+}
+
 func (c *Config) Check() error {
 	if c == nil {
 		return errors.New("config is nil")
 	}
 
-	if c.DefaultBucket == "" {
-		return errors.New("default bucket must be specified")
+	targets := c.storageTargets()
+	storageNames := make(map[string]bool, len(targets))
+	for i, target := range targets {
+		switch {
+		case target.Name == "":
+			return fmt.Errorf("storage target %d: name must be specified", i)
+		case storageNames[target.Name]:
+			return fmt.Errorf("storage target %d: duplicate name %s", i, target.Name)
+		case target.Type == "":
+			return fmt.Errorf("storage target %s: type must be specified", target.Name)
+		case target.DefaultBucket == "":
+			return fmt.Errorf("storage target %s: default bucket must be specified", target.Name)
+		}
+
+		storageNames[target.Name] = true
+	}
+
+	if _, ok := c.storageTarget(""); !ok {
+		return fmt.Errorf("unknown default storage: %s", c.DefaultStorage)
+	}
+
+	if c.ManageAria2 && c.Aria2WorkDir == "" {
+		return errors.New("aria2 work dir must be specified to manage aria2")
+	}
+
+	names := make(map[string]bool, len(c.Aria2Backends))
+	for i, backend := range c.Aria2Backends {
+		switch {
+		case backend.Name == "":
+			return fmt.Errorf("aria2 backend %d: name must be specified", i)
+		case backend.Name == managedBackendName && c.ManageAria2:
+			return fmt.Errorf("aria2 backend %d: name %s is reserved", i, managedBackendName)
+		case names[backend.Name]:
+			return fmt.Errorf("aria2 backend %d: duplicate name %s", i, backend.Name)
+		case backend.Addr == "":
+			return fmt.Errorf("aria2 backend %s: address must be specified", backend.Name)
+		}
+
+		names[backend.Name] = true
+	}
+
+	if err := c.DownloadRetry.Check(); err != nil {
+		return fmt.Errorf("download retry: %s", err)
+	}
+
+	if err := c.UploadRetry.Check(); err != nil {
+		return fmt.Errorf("upload retry: %s", err)
 	}
 
 	return nil
@@ -0,0 +1,126 @@
+package arias
+
+import (
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// eventHistorySize is the amount of state events kept around for reconnecting subscribers.
+const eventHistorySize = 1024
+
+type TaskEventType string
+
+const (
+	// StateEvent is emitted whenever a task changes its state.
+	StateEvent TaskEventType = "state"
+	// ProgressEvent periodically contains the progress of running tasks.
+	// Progress events aren't kept in the history and don't have an id.
+	ProgressEvent TaskEventType = "progress"
+)
+
+// TaskEvent contains the redacted summary of a task at the time of the event.
+type TaskEvent struct {
+	Id   uint64        `json:"id,omitempty"`
+	Type TaskEventType `json:"type"`
+	Task TaskSummary   `json:"task"`
+}
+
+// EventSubscription receives the events of an EventHub.
+type EventSubscription struct {
+	hub    *EventHub
+	taskId uuid.UUID
+
+	// Events is closed when the subscription is closed.
+	// This also happens if the subscriber can't keep up with the events,
+	// in which case it should resubscribe using the id of the last received event.
+	Events chan TaskEvent
+}
+
+// Close stops the subscription.
+func (sub *EventSubscription) Close() {
+	sub.hub.mu.Lock()
+	sub.hub.unsubscribe(sub)
+	sub.hub.mu.Unlock()
+}
+
+func (sub *EventSubscription) matches(event TaskEvent) bool {
+	return sub.taskId == uuid.Nil || sub.taskId == event.Task.Id
+}
+
+// EventHub distributes task events to subscribers.
+// It keeps a history of the most recent state events
+// so that subscribers can resume from the last event they received.
+type EventHub struct {
+	mu      sync.Mutex
+	lastId  uint64
+	history []TaskEvent
+	subs    map[*EventSubscription]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		// Using the current time in microseconds keeps ids increasing across restarts,
+		// while staying below 2^53 so that JavaScript clients don't round them
+		lastId: uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		subs:   make(map[*EventSubscription]struct{}),
+	}
+}
+
+// Publish sends an event to all subscribers.
+func (h *EventHub) Publish(eventType TaskEventType, task TaskSummary) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event := TaskEvent{Type: eventType, Task: task}
+	if eventType != ProgressEvent {
+		h.lastId++
+		event.Id = h.lastId
+
+		if len(h.history) >= eventHistorySize {
+			h.history = h.history[1:]
+		}
+		h.history = append(h.history, event)
+	}
+
+	for sub := range h.subs {
+		if !sub.matches(event) {
+			continue
+		}
+
+		select {
+		case sub.Events <- event:
+		default:
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe creates a new subscription for the events of the given task.
+// If taskId is uuid.Nil, the subscription receives the events of all tasks.
+// If lastEventId isn't 0, all events since then which are still in the history
+// are returned as well.
+func (h *EventHub) Subscribe(taskId uuid.UUID, lastEventId uint64) (sub *EventSubscription, backlog []TaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &EventSubscription{hub: h, taskId: taskId, Events: make(chan TaskEvent, 64)}
+	h.subs[sub] = struct{}{}
+
+	if lastEventId != 0 {
+		for _, event := range h.history {
+			if event.Id > lastEventId && sub.matches(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	return
+}
+
+func (h *EventHub) unsubscribe(sub *EventSubscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.Events)
+	}
+}
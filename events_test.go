@@ -0,0 +1,43 @@
+package arias
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	taskA, taskB := uuid.New(), uuid.New()
+
+	hub.Publish(StateEvent, TaskSummary{Id: taskA, Status: TaskStatus{State: "downloading"}})
+	first := hub.lastId
+	// JavaScript clients can only represent integers up to 2^53 exactly
+	assert.Less(t, first, uint64(1)<<53)
+
+	sub, backlog := hub.Subscribe(taskA, 0)
+	assert.Empty(t, backlog)
+
+	hub.Publish(StateEvent, TaskSummary{Id: taskB, Status: TaskStatus{State: "downloading"}})
+	hub.Publish(ProgressEvent, TaskSummary{Id: taskA, Status: TaskStatus{State: "downloading"}})
+	hub.Publish(StateEvent, TaskSummary{Id: taskA, Status: TaskStatus{State: "uploading"}})
+
+	progress := <-sub.Events
+	assert.Equal(t, ProgressEvent, progress.Type)
+	assert.Zero(t, progress.Id)
+
+	state := <-sub.Events
+	assert.Equal(t, StateEvent, state.Type)
+	assert.Equal(t, "uploading", state.Task.Status.State)
+
+	sub.Close()
+	_, ok := <-sub.Events
+	assert.False(t, ok)
+
+	// resuming only replays the missed state events of the task
+	_, backlog = hub.Subscribe(taskA, first)
+	assert.Equal(t, []TaskEvent{state}, backlog)
+
+	_, backlog = hub.Subscribe(uuid.Nil, first)
+	assert.Len(t, backlog, 2)
+}
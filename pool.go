@@ -0,0 +1,234 @@
+package arias
+
+import (
+	"errors"
+	"github.com/MyAnimeStream/arias/aria2"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// backendCheckInterval is the interval at which the health and load of the backends are checked.
+	backendCheckInterval = 5 * time.Second
+	// maxBackendFailures is the amount of consecutive failed checks after which a backend is considered dead.
+	maxBackendFailures = 3
+)
+
+// ErrNoBackend is returned when there is no healthy aria2 backend to place a download on.
+var ErrNoBackend = errors.New("no aria2 backend available")
+
+// Backend is an aria2 instance downloads can be placed on.
+type Backend struct {
+	Name   string
+	Client *aria2.Client
+
+	mu       sync.RWMutex
+	healthy  bool
+	failures int
+	stat     aria2.GlobalStat
+	// assigned is the amount of downloads placed on the backend since the last check,
+	// so that downloads started at the same time are spread across the backends.
+	assigned uint
+	// orphans are the GIDs of downloads which couldn't be removed while the backend was unreachable
+	orphans []string
+}
+
+func NewBackend(name string, client *aria2.Client) *Backend {
+	return &Backend{Name: name, Client: client, healthy: true}
+}
+
+// Healthy reports whether the backend responded to the recent checks.
+func (b *Backend) Healthy() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.healthy
+}
+
+// load returns the amount of downloads on the backend.
+func (b *Backend) load() uint {
+	return b.stat.NumActive + b.stat.NumWaiting + b.assigned
+}
+
+// orphan remembers a download which couldn't be removed, it's removed once the backend is reachable again.
+func (b *Backend) orphan(gid string) {
+	b.mu.Lock()
+	b.orphans = append(b.orphans, gid)
+	b.mu.Unlock()
+}
+
+// removeOrphans removes the downloads which couldn't be removed before.
+// Downloads which still can't be removed because of a temporary error are kept for the next attempt.
+func (b *Backend) removeOrphans() {
+	b.mu.Lock()
+	gids := b.orphans
+	b.orphans = nil
+	b.mu.Unlock()
+
+	for _, gid := range gids {
+		if err := b.Client.Delete(gid); err != nil {
+			log.Printf("aria2 backend %s: couldn't remove download %s: %s\n", b.Name, gid, err)
+			if isRetryable(err) {
+				b.orphan(gid)
+			}
+		}
+	}
+}
+
+// BackendStatus describes the state of a backend.
+type BackendStatus struct {
+	Name          string `json:"name"`
+	Healthy       bool   `json:"healthy"`
+	Active        uint   `json:"active"`
+	Waiting       uint   `json:"waiting"`
+	DownloadSpeed uint   `json:"downloadSpeed"`
+}
+
+// BackendPool places downloads on the least loaded of several aria2 backends.
+type BackendPool struct {
+	backends []*Backend
+	byName   map[string]*Backend
+
+	// onDown is called when a backend is considered dead
+	onDown func(b *Backend)
+}
+
+func NewBackendPool(backends []*Backend) *BackendPool {
+	p := &BackendPool{backends: backends, byName: make(map[string]*Backend, len(backends))}
+	for _, b := range backends {
+		p.byName[b.Name] = b
+	}
+
+	return p
+}
+
+// Get returns the backend with the given name.
+func (p *BackendPool) Get(name string) (*Backend, bool) {
+	b, ok := p.byName[name]
+	return b, ok
+}
+
+// Pick returns the healthy backend with the least downloads.
+// If several backends have the same amount of downloads, the one with the lowest download speed is used.
+func (p *BackendPool) Pick() (*Backend, error) {
+	var best *Backend
+	var bestLoad, bestSpeed uint
+
+	for _, b := range p.backends {
+		b.mu.RLock()
+		healthy, load, speed := b.healthy, b.load(), b.stat.DownloadSpeed
+		b.mu.RUnlock()
+
+		if !healthy {
+			continue
+		}
+
+		if best == nil || load < bestLoad || (load == bestLoad && speed < bestSpeed) {
+			best, bestLoad, bestSpeed = b, load, speed
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoBackend
+	}
+
+	best.mu.Lock()
+	best.assigned++
+	best.mu.Unlock()
+
+	return best, nil
+}
+
+// Statuses returns the statuses of all backends sorted by their names.
+func (p *BackendPool) Statuses() []BackendStatus {
+	statuses := make([]BackendStatus, len(p.backends))
+	for i, b := range p.backends {
+		b.mu.RLock()
+		statuses[i] = BackendStatus{
+			Name:          b.Name,
+			Healthy:       b.healthy,
+			Active:        b.stat.NumActive,
+			Waiting:       b.stat.NumWaiting,
+			DownloadSpeed: b.stat.DownloadSpeed,
+		}
+		b.mu.RUnlock()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// monitor periodically checks all backends until done is closed.
+func (p *BackendPool) monitor(done <-chan struct{}) {
+	ticker := time.NewTicker(backendCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, b := range p.backends {
+			wg.Add(1)
+			go func(b *Backend) {
+				defer wg.Done()
+				p.check(b)
+			}(b)
+		}
+		wg.Wait()
+	}
+}
+
+// check updates the load of the backend and detects when it died.
+func (p *BackendPool) check(b *Backend) {
+	stat, err := b.Client.GetGlobalStat()
+
+	b.mu.Lock()
+	if err == nil {
+		if !b.healthy {
+			log.Printf("aria2 backend %s is available again\n", b.Name)
+		}
+
+		b.healthy = true
+		b.failures = 0
+		b.stat = stat
+		b.assigned = 0
+		orphaned := len(b.orphans) > 0
+		b.mu.Unlock()
+
+		if orphaned {
+			b.removeOrphans()
+		}
+		return
+	}
+
+	b.failures++
+	died := b.healthy && b.failures >= maxBackendFailures
+	if died {
+		b.healthy = false
+	}
+	b.mu.Unlock()
+
+	if died {
+		log.Printf("aria2 backend %s is unavailable: %s\n", b.Name, err)
+		if p.onDown != nil {
+			p.onDown(b)
+		}
+	}
+}
+
+// Close closes the clients of all backends.
+func (p *BackendPool) Close() (err error) {
+	for _, b := range p.backends {
+		if closeErr := b.Client.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return
+}
@@ -0,0 +1,52 @@
+package arias
+
+import (
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackendPoolPick(t *testing.T) {
+	a := NewBackend("a", nil)
+	a.stat = aria2.GlobalStat{NumActive: 2}
+	b := NewBackend("b", nil)
+	b.stat = aria2.GlobalStat{NumActive: 1, DownloadSpeed: 100}
+	c := NewBackend("c", nil)
+	c.stat = aria2.GlobalStat{NumActive: 1, DownloadSpeed: 50}
+	c.healthy = false
+
+	pool := NewBackendPool([]*Backend{a, b, c})
+
+	picked, err := pool.Pick()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", picked.Name)
+
+	// b now has as many downloads as a, so the one with the lower speed is used
+	a.stat.DownloadSpeed = 10
+	picked, err = pool.Pick()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", picked.Name)
+
+	a.healthy, b.healthy = false, false
+	_, err = pool.Pick()
+	assert.Equal(t, ErrNoBackend, err)
+}
+
+func TestBackendPoolMonitorStops(t *testing.T) {
+	pool := NewBackendPool(nil)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		pool.monitor(done)
+		close(stopped)
+	}()
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("monitor didn't stop")
+	}
+}
@@ -0,0 +1,120 @@
+package arias
+
+import (
+	"github.com/MyAnimeStream/arias/aria2"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the interval at which the progress of a download is refreshed.
+const progressInterval = time.Second
+
+// Progress describes how far the current stage of a task has come.
+type Progress struct {
+	// CompletedLength is the amount of bytes which have been processed
+	CompletedLength uint `json:"completed"`
+	// TotalLength is the total amount of bytes or 0 if unknown
+	TotalLength uint `json:"total"`
+	// Speed in bytes per second
+	Speed uint `json:"speed"`
+	// ETA is the estimated remaining time in seconds or 0 if unknown
+	ETA uint `json:"eta,omitempty"`
+
+	Connections uint `json:"connections,omitempty"`
+	Seeders     uint `json:"seeders,omitempty"`
+}
+
+// estimateETA calculates the remaining time based on the current speed.
+func (p *Progress) estimateETA() {
+	if p.Speed == 0 || p.TotalLength <= p.CompletedLength {
+		p.ETA = 0
+		return
+	}
+
+	p.ETA = (p.TotalLength - p.CompletedLength) / p.Speed
+}
+
+// progressStatusKeys are the keys of aria2.Status required by NewDownloadProgress.
+var progressStatusKeys = []string{"completedLength", "totalLength", "downloadSpeed", "connections", "numSeeders"}
+
+// NewDownloadProgress creates the progress of a download from its aria2 status.
+func NewDownloadProgress(status aria2.Status) *Progress {
+	p := &Progress{
+		CompletedLength: status.CompletedLength,
+		TotalLength:     status.TotalLength,
+		Speed:           status.DownloadSpeed,
+		Connections:     status.Connections,
+		Seeders:         status.NumSeeders,
+	}
+	p.estimateETA()
+
+	return p
+}
+
+// uploadProgress tracks the progress of uploading several files.
+type uploadProgress struct {
+	start     time.Time
+	total     uint
+	completed int64
+	// skipped is the amount of bytes which were already uploaded before
+	skipped uint
+
+	onProgress func(p *Progress)
+}
+
+func newUploadProgress(total uint, skipped uint, onProgress func(p *Progress)) *uploadProgress {
+	return &uploadProgress{
+		start:      time.Now(),
+		total:      total,
+		completed:  int64(skipped),
+		skipped:    skipped,
+		onProgress: onProgress,
+	}
+}
+
+// Progress returns the current progress of the upload.
+func (u *uploadProgress) Progress() *Progress {
+	completed := uint(atomic.LoadInt64(&u.completed))
+	p := &Progress{CompletedLength: completed, TotalLength: u.total}
+
+	if elapsed := time.Since(u.start).Seconds(); elapsed > 0 && completed > u.skipped {
+		p.Speed = uint(float64(completed-u.skipped) / elapsed)
+	}
+	p.estimateETA()
+
+	return p
+}
+
+// Reader wraps the reader of the next file to upload.
+func (u *uploadProgress) Reader(r io.ReadSeeker) io.ReadSeeker {
+	return &progressReader{r: r, upload: u, base: atomic.LoadInt64(&u.completed)}
+}
+
+// progressReader is an io.ReadSeeker which reports the amount of bytes read to an uploadProgress.
+type progressReader struct {
+	r      io.ReadSeeker
+	upload *uploadProgress
+	// base is the amount of bytes which were uploaded before this file
+	base int64
+}
+
+func (r *progressReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	atomic.AddInt64(&r.upload.completed, int64(n))
+
+	if r.upload.onProgress != nil {
+		r.upload.onProgress(r.upload.Progress())
+	}
+
+	return
+}
+
+func (r *progressReader) Seek(offset int64, whence int) (pos int64, err error) {
+	pos, err = r.r.Seek(offset, whence)
+	if err == nil {
+		atomic.StoreInt64(&r.upload.completed, r.base+pos)
+	}
+
+	return
+}
@@ -0,0 +1,57 @@
+package arias
+
+import (
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEstimateETA(t *testing.T) {
+	for _, c := range []struct {
+		progress Progress
+		eta      uint
+	}{
+		{Progress{CompletedLength: 100, TotalLength: 1100, Speed: 100}, 10},
+		{Progress{CompletedLength: 0, TotalLength: 150, Speed: 100}, 1},
+		{Progress{CompletedLength: 100, TotalLength: 1100, Speed: 0}, 0},
+		{Progress{CompletedLength: 100, TotalLength: 0, Speed: 100}, 0},
+		{Progress{CompletedLength: 1100, TotalLength: 1100, Speed: 100, ETA: 5}, 0},
+	} {
+		c.progress.estimateETA()
+		assert.Equal(t, c.eta, c.progress.ETA, "%+v", c.progress)
+	}
+}
+
+func TestNewDownloadProgress(t *testing.T) {
+	p := NewDownloadProgress(aria2.Status{CompletedLength: 200, TotalLength: 1200, DownloadSpeed: 50, Connections: 4, NumSeeders: 2})
+	assert.Equal(t, &Progress{CompletedLength: 200, TotalLength: 1200, Speed: 50, ETA: 20, Connections: 4, Seeders: 2}, p)
+}
+
+func TestUploadProgress(t *testing.T) {
+	var reported []uint
+	// 3 bytes were uploaded by a previous attempt
+	upload := newUploadProgress(10, 3, func(p *Progress) {
+		reported = append(reported, p.CompletedLength)
+	})
+	assert.Equal(t, uint(3), upload.Progress().CompletedLength)
+	assert.Equal(t, uint(0), upload.Progress().Speed)
+
+	r := upload.Reader(strings.NewReader("abcdefg"))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), upload.Progress().CompletedLength)
+
+	// Seeking back, like a storage retrying a part, moves the progress back as well
+	_, err = r.Seek(1, io.SeekStart)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), upload.Progress().CompletedLength)
+
+	_, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(10), upload.Progress().CompletedLength)
+	assert.Equal(t, uint(10), reported[len(reported)-1])
+}
@@ -0,0 +1,110 @@
+package arias
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy determines how often and when a failed stage of a task is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum amount of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff Duration
+	// MaxBackoff limits the delay between two attempts
+	MaxBackoff Duration
+	// Multiplier is the factor by which the delay grows after every attempt
+	Multiplier float64
+	// Jitter randomly varies the delay by up to the given fraction of it
+	Jitter float64
+}
+
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: Duration{5 * time.Second},
+		MaxBackoff:     Duration{time.Minute},
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+func (p *RetryPolicy) Check() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return errors.New("at least one attempt is required")
+	case p.Multiplier < 1:
+		return errors.New("multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("jitter must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// Backoff returns the delay before the next attempt after the given amount of failed attempts.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff.Duration) * math.Pow(p.Multiplier, float64(attempt-1))
+	if max := float64(p.MaxBackoff.Duration); max > 0 && delay > max {
+		delay = max
+	}
+
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// errBackendFailover is returned by an attempt which was aborted because its aria2 backend died.
+// The stage is retried on another backend right away without counting as a failed attempt.
+var errBackendFailover = errors.New("aria2 backend is unavailable")
+
+// permanentError marks errors which won't go away by retrying.
+type permanentError struct {
+	error
+}
+
+// isRetryable reports whether the stage which failed with the given error should be attempted again.
+// Only errors which are known to be temporary are retried, all others are considered permanent.
+// The downloader and the storages mark their errors using the Temporary or StatusCode methods.
+func isRetryable(err error) bool {
+	if err == errBackendFailover {
+		return true
+	}
+
+	var statusErr interface{ StatusCode() int }
+	var timeoutErr interface{ Timeout() bool }
+	var temporaryErr interface{ Temporary() bool }
+
+	switch {
+	case errors.As(err, &statusErr):
+		status := statusErr.StatusCode()
+		return status >= 500 || status == http.StatusTooManyRequests
+	case errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		return true
+	case errors.As(err, &temporaryErr):
+		return temporaryErr.Temporary() || isDialError(err)
+	}
+
+	return isDialError(err)
+}
+
+// isDialError reports whether the error was caused by an unreachable server.
+// Unknown hosts aren't expected to become reachable.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !errors.As(err, &dnsErr)
+}
+
+// TaskAttempt describes a failed attempt of a stage.
+type TaskAttempt struct {
+	Stage   string    `json:"stage"`
+	Attempt int       `json:"attempt"`
+	Err     string    `json:"error"`
+	Time    time.Time `json:"time"`
+	// RetryAt is the time of the next attempt, it's omitted if the stage isn't retried
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+}
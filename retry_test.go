@@ -0,0 +1,147 @@
+package arias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: Duration{time.Second},
+		MaxBackoff:     Duration{5 * time.Second},
+		Multiplier:     2,
+	}
+
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4))
+	assert.Equal(t, 5*time.Second, policy.Backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(2)
+		assert.True(t, delay >= time.Second && delay <= 3*time.Second, "delay %s out of range", delay)
+	}
+}
+
+// statusCodeError is an error with a status code like the errors of the aws sdk.
+type statusCodeError int
+
+func (e statusCodeError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+func (e statusCodeError) StatusCode() int {
+	return int(e)
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"failover", errBackendFailover, true},
+		{"temporary download error", &aria2.DownloadError{ExitStatus: aria2.NetworkError}, true},
+		{"permanent download error", &aria2.DownloadError{ExitStatus: aria2.ResourceNotFound}, false},
+		{"wrapped download error", fmt.Errorf("download: %w", &aria2.DownloadError{ExitStatus: aria2.Timeout}), true},
+		{"lost download", &aria2.LostError{GID: "2089b05ecca3d829", Err: errors.New("GID is not found")}, true},
+		{"permanent", permanentError{errors.New("no files were selected")}, false},
+		{"cancelled", context.Canceled, false},
+		{"unknown", errors.New("something went wrong"), false},
+		{"timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"unknown host", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Name: "example.invalid", IsNotFound: true}}, false},
+		{"ftp transient", ftpError(&textproto.Error{Code: 421, Msg: "too many connections"}), true},
+		{"ftp permanent", ftpError(&textproto.Error{Code: 553, Msg: "not allowed"}), false},
+		{"unmarked ftp error", &textproto.Error{Code: 421, Msg: "too many connections"}, false},
+		{"http server error", &httpStatusError{err: errors.New("bad gateway"), status: 502}, true},
+		{"http forbidden", &httpStatusError{err: errors.New("forbidden"), status: 403}, false},
+		{"wrapped temporary error", fmt.Errorf("upload: %w", &temporaryError{err: errors.New("busy"), temporary: true}), true},
+		{"s3 server error", statusCodeError(500), true},
+		{"s3 throttled", statusCodeError(429), true},
+		{"s3 access denied", statusCodeError(403), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.retryable, isRetryable(test.err))
+		})
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: Duration{time.Millisecond}, Multiplier: 1}
+	temporary := &aria2.DownloadError{ExitStatus: aria2.NetworkError}
+	permanent := permanentError{errors.New("no files were selected")}
+
+	tests := []struct {
+		name string
+		// errs are returned by the attempts in order, the attempts succeed once they run out
+		errs     []error
+		err      error
+		attempts int
+	}{
+		{"success", nil, nil, 0},
+		{"retried", []error{temporary, temporary}, nil, 2},
+		{"exhausted", []error{temporary, temporary, temporary}, temporary, 3},
+		{"permanent", []error{temporary, permanent}, permanent, 2},
+		{"failover", []error{errBackendFailover, temporary, errBackendFailover, errBackendFailover, temporary}, nil, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := NewDownloadTask(newTestServer(t), DownloadRequest{Url: "https://example.org/ep1.mkv"}).(*downloadTask)
+
+			var calls, resets int
+			err := task.withRetries("download", policy, func() error {
+				calls++
+				if calls > len(test.errs) {
+					return nil
+				}
+				return test.errs[calls-1]
+			}, func() { resets++ })
+
+			assert.Equal(t, test.err, err)
+
+			attempts := task.GetStatus().Attempts
+			if assert.Len(t, attempts, test.attempts) && test.attempts > 0 {
+				for i, attempt := range attempts {
+					assert.Equal(t, i+1, attempt.Attempt, "failover must not use up an attempt")
+				}
+
+				last := attempts[len(attempts)-1]
+				assert.Equal(t, test.err == nil, last.RetryAt != nil)
+			}
+
+			// every attempt after the first one is preceded by a reset
+			if test.err == nil {
+				assert.Equal(t, calls-1, resets)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		task := NewDownloadTask(newTestServer(t), DownloadRequest{Url: "https://example.org/ep1.mkv"}).(*downloadTask)
+		policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: Duration{time.Hour}, Multiplier: 1}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			task.cancel()
+		}()
+
+		err := task.withRetries("download", policy, func() error { return temporary }, nil)
+		assert.Equal(t, context.Canceled, err)
+		assert.Len(t, task.GetStatus().Attempts, 1)
+	})
+}
@@ -1,40 +1,769 @@
 package arias
 
-import "errors"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/google/uuid"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes why the value of a request field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError contains all problems found in a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Add records a problem with the given field.
+func (e *ValidationError) Add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+// ErrOrNil returns nil if no problems were recorded.
+func (e *ValidationError) ErrOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = field.Field + ": " + field.Message
+	}
+
+	return strings.Join(problems, "; ")
+}
+
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// maxMirrorWeight is the maximum weight of a mirror.
+const maxMirrorWeight = 16
+
+// Mirror is an additional url pointing to the requested resource.
+// In JSON it can either be an object or just the url.
+type Mirror struct {
+	Url string `json:"url"`
+	// Weight is the amount of times the mirror is passed to aria2,
+	// mirrors with a higher weight receive more connections.
+	Weight uint `json:"weight,omitempty"`
+	// Priority determines the order in which the mirrors are used, lower priorities are used first.
+	// As long as no priority is set, aria2 selects mirrors based on their speed.
+	Priority int `json:"priority,omitempty"`
+}
+
+func (m *Mirror) UnmarshalJSON(data []byte) error {
+	var rawUrl string
+	if err := json.Unmarshal(data, &rawUrl); err == nil {
+		*m = Mirror{Url: rawUrl}
+		return nil
+	}
+
+	type mirror Mirror
+	return json.Unmarshal(data, (*mirror)(m))
+}
+
+func init() {
+	schemaDecoder.RegisterConverter(Mirror{}, func(value string) reflect.Value {
+		return reflect.ValueOf(Mirror{Url: value})
+	})
+}
+
+// DownloadOptions are the aria2 options a request may set.
+// Options which could affect the server, like the download directory, aren't allowed.
+type DownloadOptions struct {
+	Referer   string `json:"referer,omitempty"`
+	UserAgent string `json:"user-agent,omitempty"`
+	// Cookies is the value of the Cookie header, e.g. "a=1; b=2"
+	Cookies string `json:"cookies,omitempty"`
+
+	Split                  uint `json:"split,omitempty"`
+	MaxConnectionPerServer uint `json:"max-connection-per-server,omitempty"`
+	// MaxDownloadLimit and LowestSpeedLimit are in bytes per second
+	MaxDownloadLimit uint `json:"max-download-limit,omitempty"`
+	LowestSpeedLimit uint `json:"lowest-speed-limit,omitempty"`
+	// Timeout and ConnectTimeout are in seconds
+	Timeout        uint `json:"timeout,omitempty"`
+	ConnectTimeout uint `json:"connect-timeout,omitempty"`
+
+	// Checksum has the form TYPE=DIGEST, e.g. "sha-1=0192ba11326fe2298c8cb4de616f4d4140213838"
+	Checksum string `json:"checksum,omitempty"`
+
+	AllProxy         string `json:"all-proxy,omitempty"`
+	AllProxyUser     string `json:"all-proxy-user,omitempty"`
+	AllProxyPassword string `json:"all-proxy-passwd,omitempty"`
+	HttpUser         string `json:"http-user,omitempty"`
+	HttpPassword     string `json:"http-passwd,omitempty"`
+}
+
+// withDefaults returns the options with unset fields taken from defaults.
+func (o DownloadOptions) withDefaults(defaults DownloadOptions) DownloadOptions {
+	v := reflect.ValueOf(&o).Elem()
+	d := reflect.ValueOf(defaults)
+
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.IsZero() {
+			field.Set(d.Field(i))
+		}
+	}
+
+	return o
+}
+
+// check validates the options against the limits of the config.
+func (o *DownloadOptions) check(errs *ValidationError, c *Config) {
+	if c.MaxSplit > 0 && o.Split > c.MaxSplit {
+		errs.Add("options.split", "split mustn't exceed %d", c.MaxSplit)
+	}
+
+	if c.MaxConnectionPerServer > 0 && o.MaxConnectionPerServer > c.MaxConnectionPerServer {
+		errs.Add("options.max-connection-per-server", "max-connection-per-server mustn't exceed %d", c.MaxConnectionPerServer)
+	}
+
+	if o.Checksum != "" {
+		if parts := strings.SplitN(o.Checksum, "=", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs.Add("options.checksum", "checksum must have the form TYPE=DIGEST")
+		}
+	}
+
+	if o.AllProxy != "" {
+		checkUrl(errs, "options.all-proxy", o.AllProxy)
+	}
+
+	for field, value := range map[string]string{"referer": o.Referer, "user-agent": o.UserAgent, "cookies": o.Cookies} {
+		if strings.ContainsAny(value, "\r\n") {
+			errs.Add("options."+field, "invalid value")
+		}
+	}
+}
+
+// apply sets the options on the aria2 options.
+func (o *DownloadOptions) apply(options *aria2.Options) {
+	options.Referer = o.Referer
+	options.UserAgent = o.UserAgent
+	options.Split = o.Split
+	options.MaxConnectionPerServer = o.MaxConnectionPerServer
+	options.MaxDownloadLimit = o.MaxDownloadLimit
+	options.LowestSpeedLimit = o.LowestSpeedLimit
+	options.Timeout = o.Timeout
+	options.ConnectTimeout = o.ConnectTimeout
+	options.Checksum = o.Checksum
+	options.AllProxy = o.AllProxy
+	options.AllProxyUser = o.AllProxyUser
+	options.AllProxyPassword = o.AllProxyPassword
+	options.HttpUser = o.HttpUser
+	options.HttpPasswd = o.HttpPassword
+
+	if o.Cookies != "" {
+		options.Header = append(options.Header, "Cookie: "+o.Cookies)
+	}
+}
 
 type DownloadRequest struct {
-	Url    string `schema:"url"`
-	Bucket string `schema:"bucket"`
-	Name   string `schema:"name"`
+	Url     string   `schema:"url" json:"url"`
+	Mirrors []Mirror `schema:"mirror" json:"mirrors,omitempty"`
+	// Storage is the name of the storage target to upload to, the default target is used if empty
+	Storage string `schema:"storage" json:"storage,omitempty"`
+	Bucket  string `schema:"bucket" json:"bucket"`
+	// Name is the template for the name of the uploaded files.
+	// It may contain the placeholders {path}, {dir}, {filename}, {name} and {ext}.
+	Name string `schema:"name" json:"name,omitempty"`
+	// Files selects the files to upload if the download contains multiple files.
+	// Files may be selected by their index (starting at 1), a range of indices like "2-5",
+	// or a glob pattern matching their path (or their filename if the pattern doesn't contain a slash).
+	Files []string `schema:"file" json:"files,omitempty"`
+
+	CallbackUrl string `schema:"callback" json:"callback,omitempty"`
+
+	// Headers are sent with every http request aria2 makes
+	Headers map[string]string `schema:"-" json:"headers,omitempty"`
+	// Options are passed to aria2 when adding the download.
+	// Unset options are taken from the config.
+	Options *DownloadOptions `schema:"-" json:"options,omitempty"`
+	// Torrent is the content of a torrent file to download instead of the url.
+	// The url and mirrors are used as web seeds.
+	Torrent []byte `schema:"-" json:"torrent,omitempty"`
+	// Metalink is the content of a metalink file to download instead of the url.
+	Metalink []byte `schema:"-" json:"metalink,omitempty"`
 
-	CallbackUrl string `schema:"callback"`
+	// Metadata isn't used by arias, it's included in the status of the task
+	Metadata map[string]string `schema:"-" json:"metadata,omitempty"`
 }
 
 func (req *DownloadRequest) UseConfig(c *Config) error {
-	if req.Bucket == "" {
-		req.Bucket = c.DefaultBucket
-	} else if !c.AllowBucketOverride {
-		return errors.New("bucket override forbidden")
+	var errs ValidationError
+
+	if target, ok := c.storageTarget(req.Storage); !ok {
+		errs.Add("storage", "unknown storage")
+	} else {
+		req.Storage = target.Name
+
+		if req.Bucket == "" {
+			req.Bucket = target.DefaultBucket
+		} else if !target.AllowsBucket(req.Bucket) {
+			errs.Add("bucket", "bucket not allowed for storage %s", target.Name)
+		}
 	}
 
 	if req.Name == "" && !c.AllowNoName {
-		return errors.New("name must be provided")
+		errs.Add("name", "name must be provided")
+	}
+
+	var options DownloadOptions
+	if req.Options != nil {
+		options = *req.Options
 	}
+	options.check(&errs, c)
+
+	options = options.withDefaults(c.DownloadOptions)
+	req.Options = &options
 
-	return nil
+	return errs.ErrOrNil()
+}
+
+func checkUrl(errs *ValidationError, field string, rawUrl string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme == "" {
+		errs.Add(field, "invalid url")
+	}
 }
 
 func (req *DownloadRequest) Check() error {
-	switch {
-	case req == nil:
+	if req == nil {
 		return errors.New("request is empty")
-	case req.Url == "":
-		return errors.New("url not specified")
 	}
 
-	return nil
+	var errs ValidationError
+
+	switch {
+	case req.Torrent != nil && req.Metalink != nil:
+		errs.Add("metalink", "torrent and metalink can't be combined")
+	case req.Metalink != nil && (req.Url != "" || len(req.Mirrors) > 0):
+		errs.Add("url", "metalinks can't be combined with urls")
+	case req.Url == "" && req.Torrent == nil && req.Metalink == nil:
+		errs.Add("url", "url not specified")
+	}
+
+	if req.Url != "" {
+		checkUrl(&errs, "url", req.Url)
+	}
+
+	for i, mirror := range req.Mirrors {
+		field := fmt.Sprintf("mirrors.%d", i)
+		checkUrl(&errs, field+".url", mirror.Url)
+
+		if mirror.Weight > maxMirrorWeight {
+			errs.Add(field+".weight", "weight mustn't exceed %d", maxMirrorWeight)
+		}
+	}
+
+	if req.CallbackUrl != "" {
+		checkUrl(&errs, "callback", req.CallbackUrl)
+	}
+
+	for i, selector := range req.Files {
+		if fileIndexPattern.MatchString(selector) {
+			continue
+		}
+
+		if _, err := path.Match(selector, ""); err != nil {
+			errs.Add(fmt.Sprintf("files.%d", i), "invalid pattern")
+		}
+	}
+
+	for name, value := range req.Headers {
+		if name == "" || strings.ContainsAny(name, ":\r\n") || strings.ContainsAny(value, "\r\n") {
+			errs.Add("headers."+name, "invalid header")
+		}
+	}
+
+	return errs.ErrOrNil()
+}
+
+// Validate applies the config and checks the request, reporting all problems at once.
+func (req *DownloadRequest) Validate(c *Config) error {
+	var errs ValidationError
+
+	for _, err := range []error{req.UseConfig(c), req.Check()} {
+		if fieldErrs, ok := err.(*ValidationError); ok {
+			errs.Fields = append(errs.Fields, fieldErrs.Fields...)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return errs.ErrOrNil()
+}
+
+var fileIndexPattern = regexp.MustCompile(`^(\d+)(?:-(\d+))?$`)
+
+// fileIndices returns the file selectors which are indices or ranges of indices.
+func (req *DownloadRequest) fileIndices() (indices []string) {
+	for _, selector := range req.Files {
+		if fileIndexPattern.MatchString(selector) {
+			indices = append(indices, selector)
+		}
+	}
+
+	return
+}
+
+// hasFileGlobs reports whether any file is selected by a glob pattern.
+func (req *DownloadRequest) hasFileGlobs() bool {
+	return len(req.fileIndices()) < len(req.Files)
+}
+
+// SelectsFile reports whether the file with the given index and relative path was selected.
+// If no files were selected explicitly, all files are.
+func (req *DownloadRequest) SelectsFile(index int, relPath string) bool {
+	if len(req.Files) == 0 {
+		return true
+	}
+
+	for _, selector := range req.Files {
+		if match := fileIndexPattern.FindStringSubmatch(selector); match != nil {
+			start, _ := strconv.Atoi(match[1])
+			end := start
+			if match[2] != "" {
+				end, _ = strconv.Atoi(match[2])
+			}
+
+			if start <= index && index <= end {
+				return true
+			}
+
+			continue
+		}
+
+		name := relPath
+		if !strings.Contains(selector, "/") {
+			name = path.Base(relPath)
+		}
+
+		if ok, _ := path.Match(selector, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasPriorities reports whether any of the mirrors has a priority.
+func (req *DownloadRequest) hasPriorities() bool {
+	for _, mirror := range req.Mirrors {
+		if mirror.Priority != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// URIs returns the url and all mirrors of the request ordered by their priority.
+// Mirrors are repeated according to their weight.
+func (req *DownloadRequest) URIs() []string {
+	var mirrors []Mirror
+	if req.Url != "" {
+		mirrors = append(mirrors, Mirror{Url: req.Url})
+	}
+	mirrors = append(mirrors, req.Mirrors...)
+
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		return mirrors[i].Priority < mirrors[j].Priority
+	})
+
+	var uris []string
+	for _, mirror := range mirrors {
+		uris = append(uris, mirror.Url)
+		for i := uint(1); i < mirror.Weight; i++ {
+			uris = append(uris, mirror.Url)
+		}
+	}
+
+	return uris
+}
+
+// AriaOptions returns the options to use for the aria2 download.
+func (req *DownloadRequest) AriaOptions() *aria2.Options {
+	var options aria2.Options
+	if req.Options != nil {
+		req.Options.apply(&options)
+	}
+
+	// Indices can be selected directly, patterns are matched once the file list
+	// is known. Downloads created from metadata are paused until then.
+	if req.hasFileGlobs() {
+		options.PauseMetadata = true
+		// The files of torrents and metalinks are known immediately
+		options.Pause = req.Torrent != nil || req.Metalink != nil
+	} else if indices := req.fileIndices(); len(indices) > 0 {
+		options.SelectFile = strings.Join(indices, ",")
+	}
+
+	// aria2 only respects the order of the uris with the inorder selector
+	if options.UriSelector == "" && req.hasPriorities() {
+		options.UriSelector = "inorder"
+	}
+
+	names := make([]string, 0, len(req.Headers))
+	for name := range req.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		options.Header = append(options.Header, name+": "+req.Headers[name])
+	}
+
+	return &options
 }
 
 type DownloadResponse struct {
 	Id string `json:"id"`
 }
+
+// taskStates are the states a task can be in.
+var taskStates = []string{"waiting", "started", "downloading", "paused", "uploading", "done", "error", "cancelled"}
+
+const (
+	defaultTaskListLimit = 50
+	maxTaskListLimit     = 500
+)
+
+// TaskQuery filters, sorts and paginates the task list.
+type TaskQuery struct {
+	// States only includes tasks in one of the given states.
+	States  []string `schema:"state"`
+	Storage string   `schema:"storage"`
+	Bucket  string   `schema:"bucket"`
+	// Search matches tasks whose url or name contains the given text
+	Search        string `schema:"q"`
+	CreatedAfter  string `schema:"createdAfter"`
+	CreatedBefore string `schema:"createdBefore"`
+
+	// Sort is either "created" or "-created" for descending order
+	Sort   string `schema:"sort"`
+	Limit  int    `schema:"limit"`
+	Cursor string `schema:"cursor"`
+
+	createdAfter, createdBefore time.Time
+	cursor                      *taskCursor
+}
+
+// taskCursor denotes the position of the last task of a page.
+type taskCursor struct {
+	CreatedAt time.Time
+	Id        uuid.UUID
+}
+
+func parseTaskCursor(raw string) (*taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(data), "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &taskCursor{createdAt, id}, nil
+}
+
+func (c *taskCursor) String() string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "/" + c.Id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseQueryTime(name, value string) (t time.Time, err error) {
+	if value == "" {
+		return
+	}
+
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		err = fmt.Errorf("%s must be a RFC 3339 timestamp", name)
+	}
+
+	return
+}
+
+// Check validates the query and fills in the defaults.
+func (q *TaskQuery) Check() (err error) {
+	var states []string
+	for _, state := range q.States {
+		// states may also be passed as a comma separated list
+		states = append(states, strings.Split(state, ",")...)
+	}
+	q.States = states
+
+	for _, state := range q.States {
+		if !containsString(taskStates, state) {
+			return fmt.Errorf("unknown state: %s", state)
+		}
+	}
+
+	if q.createdAfter, err = parseQueryTime("createdAfter", q.CreatedAfter); err != nil {
+		return
+	}
+	if q.createdBefore, err = parseQueryTime("createdBefore", q.CreatedBefore); err != nil {
+		return
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = "-created"
+	case "created", "-created":
+	default:
+		return fmt.Errorf("unknown sort order: %s", q.Sort)
+	}
+
+	switch {
+	case q.Limit == 0:
+		q.Limit = defaultTaskListLimit
+	case q.Limit < 0 || q.Limit > maxTaskListLimit:
+		return fmt.Errorf("limit must be between 1 and %d", maxTaskListLimit)
+	}
+
+	if q.Cursor != "" {
+		q.cursor, err = parseTaskCursor(q.Cursor)
+	}
+
+	return
+}
+
+// Matches reports whether the record passes the filters of the query.
+func (q *TaskQuery) Matches(record TaskRecord) bool {
+	status := record.Status
+	req := record.Request
+
+	switch {
+	case len(q.States) > 0 && !containsString(q.States, status.State):
+		return false
+	case q.Storage != "" && q.Storage != req.Storage:
+		return false
+	case q.Bucket != "" && q.Bucket != req.Bucket:
+		return false
+	case !q.createdAfter.IsZero() && status.CreatedAt.Before(q.createdAfter):
+		return false
+	case !q.createdBefore.IsZero() && !status.CreatedAt.Before(q.createdBefore):
+		return false
+	}
+
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(req.Url), search) && !strings.Contains(strings.ToLower(req.Name), search) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// less reports whether record a comes before b in the sort order of the query.
+func (q *TaskQuery) less(a, b taskCursor) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		if q.Sort == "created" {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+
+	return a.Id.String() < b.Id.String()
+}
+
+// Apply filters, sorts and paginates the given records.
+// It returns the records of the requested page and the cursor of the next page,
+// which is empty if there are no more records.
+func (q *TaskQuery) Apply(records []TaskRecord) (page []TaskRecord, next string) {
+	page = []TaskRecord{}
+	for _, record := range records {
+		if q.Matches(record) && (q.cursor == nil || q.less(*q.cursor, cursorOf(record))) {
+			page = append(page, record)
+		}
+	}
+
+	sort.Slice(page, func(i, j int) bool {
+		return q.less(cursorOf(page[i]), cursorOf(page[j]))
+	})
+
+	if len(page) > q.Limit {
+		page = page[:q.Limit]
+		last := cursorOf(page[len(page)-1])
+		next = last.String()
+	}
+
+	return
+}
+
+func cursorOf(record TaskRecord) taskCursor {
+	return taskCursor{record.Status.CreatedAt, record.Id}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TaskSummary is the part of a task record which can be listed.
+// The request isn't included because its urls, headers and options may contain credentials.
+type TaskSummary struct {
+	Id uuid.UUID `json:"id"`
+	// Host is the host of the url, empty for torrents and metalinks
+	Host    string     `json:"host,omitempty"`
+	Name    string     `json:"name,omitempty"`
+	Storage string     `json:"storage,omitempty"`
+	Bucket  string     `json:"bucket"`
+	Status  TaskStatus `json:"status"`
+}
+
+func NewTaskSummary(record TaskRecord) TaskSummary {
+	req := record.Request
+
+	var host string
+	if u, err := url.Parse(req.Url); err == nil {
+		host = u.Host
+	}
+
+	status := record.Status.Redacted()
+
+	return TaskSummary{
+		Id:      record.Id,
+		Host:    host,
+		Name:    req.Name,
+		Storage: req.Storage,
+		Bucket:  req.Bucket,
+		Status:  status,
+	}
+}
+
+// urlPattern matches the urls in error messages.
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// redactURL removes the credentials, query and fragment from the url,
+// since signed urls contain their signature there.
+// Strings which aren't absolute urls are returned unchanged.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	return u.String()
+}
+
+// redactURLs redacts all urls in the text.
+func redactURLs(text string) string {
+	return urlPattern.ReplaceAllStringFunc(text, func(match string) string {
+		// punctuation at the end belongs to the text around the url
+		trimmed := strings.TrimRight(match, ".,:;!?)]")
+		return redactURL(trimmed) + match[len(trimmed):]
+	})
+}
+
+// redactResult redacts the paths of the upload outputs of a result.
+// Results read from the task store are decoded into maps.
+func redactResult(result interface{}) interface{} {
+	switch result := result.(type) {
+	case []UploadOutput:
+		redacted := make([]UploadOutput, len(result))
+		for i, output := range result {
+			output.Path = redactURL(output.Path)
+			redacted[i] = output
+		}
+		return redacted
+	case UploadOutput:
+		result.Path = redactURL(result.Path)
+		return result
+	case []interface{}:
+		redacted := make([]interface{}, len(result))
+		for i, output := range result {
+			redacted[i] = redactResult(output)
+		}
+		return redacted
+	case map[string]interface{}:
+		p, ok := result["path"].(string)
+		if !ok {
+			return result
+		}
+
+		redacted := make(map[string]interface{}, len(result))
+		for key, value := range result {
+			redacted[key] = value
+		}
+		redacted["path"] = redactURL(p)
+		return redacted
+	}
+
+	return result
+}
+
+// Redacted returns a copy of the status which can be shown to clients.
+// The uris of aria2 are removed and urls elsewhere are redacted,
+// because the urls of a download may be signed or contain credentials.
+func (status TaskStatus) Redacted() TaskStatus {
+	status.URIs = nil
+	status.Result = redactResult(status.Result)
+
+	if status.Err != nil {
+		taskErr := *status.Err
+		taskErr.URI = redactURL(taskErr.URI)
+		taskErr.Message = redactURLs(taskErr.Message)
+		status.Err = &taskErr
+	}
+
+	if status.Attempts != nil {
+		attempts := make([]TaskAttempt, len(status.Attempts))
+		for i, attempt := range status.Attempts {
+			attempt.Err = redactURLs(attempt.Err)
+			attempts[i] = attempt
+		}
+		status.Attempts = attempts
+	}
+
+	return status
+}
+
+type TaskListResponse struct {
+	Tasks []TaskSummary `json:"tasks"`
+	// Next is the cursor of the next page
+	Next string `json:"next,omitempty"`
+}
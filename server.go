@@ -9,13 +9,23 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/google/uuid"
 	"github.com/gorilla/schema"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 const Version = "0.1.1"
 
+// maxRequestBodySize is the maximum size of a request body.
+// It has to be large enough for torrent and metalink files.
+const maxRequestBodySize = 10 << 20
+
 var schemaDecoder = schema.NewDecoder()
 
 type Server struct {
@@ -23,19 +33,143 @@ type Server struct {
 	HttpClient *http.Client
 	Config     Config
 
-	AriaClient aria2.Client
-	Storage    Storage
+	Backends *BackendPool
+	// Storages are the storages of the storage targets by their name
+	Storages map[string]Storage
+	Store    TaskStore
+	Events   *EventHub
+
+	tasksLock sync.RWMutex
+	tasks     map[uuid.UUID]Task
 
-	tasks map[uuid.UUID]Task
+	// ariaProcess is the aria2c process started by arias, if any
+	ariaProcess *aria2.Process
+	// done is closed when the server is closed to stop the background work
+	done chan struct{}
 }
 
-func NewServer(config Config) (s Server, err error) {
-	ariaClient, err := aria2.Dial(config.Aria2Addr)
+// taskPruneInterval is the interval at which expired task records are deleted.
+const taskPruneInterval = time.Hour
+
+// startAria2 starts the aria2c process managed by arias and connects to it.
+func startAria2(config Config) (*aria2.Process, *aria2.Client, error) {
+	process, err := aria2.StartProcess(aria2.ProcessOptions{
+		Path:    config.Aria2Path,
+		WorkDir: config.Aria2WorkDir,
+		Dir:     config.Aria2Dir,
+		Options: config.Aria2Options,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := aria2.Dial(process.Addr(), aria2.WithSecret(process.Secret()))
+	if err != nil {
+		_ = process.Stop(nil)
+		return nil, nil, err
+	}
+
+	return process, client, nil
+}
+
+// defaultBackendName is the name of the backend at Config.Aria2Addr
+// and managedBackendName the one of the aria2c process managed by arias.
+const (
+	defaultBackendName = "default"
+	managedBackendName = "managed"
+)
+
+// connectBackends connects to the aria2 backends of the config.
+func connectBackends(config Config) (backends []*Backend, process *aria2.Process, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, b := range backends {
+			_ = b.Client.Close()
+		}
+		if process != nil {
+			_ = process.Stop(nil)
+		}
+	}()
+
+	if config.ManageAria2 {
+		var client *aria2.Client
+		process, client, err = startAria2(config)
+		if err != nil {
+			return
+		}
+
+		backends = append(backends, NewBackend(managedBackendName, client))
+	}
+
+	configured := config.Aria2Backends
+	if len(configured) == 0 && !config.ManageAria2 {
+		configured = []Aria2Backend{{Name: defaultBackendName, Addr: config.Aria2Addr, Secret: config.Aria2Secret}}
+	}
+
+	for _, backend := range configured {
+		var client *aria2.Client
+		client, err = aria2.Dial(backend.Addr, aria2.WithSecret(backend.Secret))
+		if err != nil {
+			err = fmt.Errorf("aria2 backend %s: %s", backend.Name, err)
+			return
+		}
+
+		backends = append(backends, NewBackend(backend.Name, client))
+	}
+
+	return
+}
+
+// newStorages creates the storages of all storage targets.
+func newStorages(config Config) (map[string]Storage, error) {
+	storages := make(map[string]Storage)
+	for _, target := range config.storageTargets() {
+		storage, err := NewStorageFromTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("storage %s: %s", target.Name, err)
+		}
+
+		storages[target.Name] = storage
+	}
+
+	return storages, nil
+}
+
+func NewServer(config Config) (s *Server, err error) {
+	backends, ariaProcess, err := connectBackends(config)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// Once the server exists, it closes the store along with the backends and the process
+		if s != nil {
+			_ = s.Close()
+			s = nil
+			return
+		}
+
+		for _, b := range backends {
+			_ = b.Client.Close()
+		}
+		if ariaProcess != nil {
+			_ = ariaProcess.Stop(nil)
+		}
+	}()
+
+	storages, err := newStorages(config)
 	if err != nil {
 		return
 	}
 
-	storage, err := NewStorageFromType(config.StorageType)
+	store, err := NewTaskStoreFromPath(config.TaskStorePath)
 	if err != nil {
 		return
 	}
@@ -47,20 +181,34 @@ func NewServer(config Config) (s Server, err error) {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	r.Use(middleware.Timeout(60 * time.Second))
-
-	s = Server{
+	s = &Server{
 		Router:     r,
 		HttpClient: &http.Client{Timeout: 30 * time.Second},
 		Config:     config,
 
-		AriaClient: ariaClient,
-		Storage:    storage,
+		Backends: NewBackendPool(backends),
+		Storages: storages,
+		Store:    store,
+		Events:   NewEventHub(),
 
 		tasks: make(map[uuid.UUID]Task),
+
+		ariaProcess: ariaProcess,
+		done:        make(chan struct{}),
 	}
 
+	s.Backends.onDown = s.failover
+	go s.Backends.monitor(s.done)
+
 	s.addHandlers()
+	go s.publishProgress()
+
+	err = s.restoreTasks()
+	if err != nil {
+		return
+	}
+
+	go s.pruneTasks()
 	return
 }
 
@@ -70,14 +218,161 @@ func (s *Server) ListenAndServe() error {
 	return http.ListenAndServe(addr, s.Router)
 }
 
+// Close shuts down the managed aria2 process, if any, and closes the task store.
+// Running tasks are resumed when the server is started again.
+func (s *Server) Close() error {
+	close(s.done)
+
+	var err error
+	if s.ariaProcess != nil {
+		managed, _ := s.Backends.Get(managedBackendName)
+		err = s.ariaProcess.Stop(managed.Client)
+	}
+
+	_ = s.Backends.Close()
+
+	if storeErr := s.Store.Close(); err == nil {
+		err = storeErr
+	}
+
+	return err
+}
+
 func (s *Server) PerformTask(task Task) {
 	id := task.GetId()
+
+	s.tasksLock.Lock()
 	s.tasks[id] = task
+	s.tasksLock.Unlock()
+
 	go func() {
 		_ = task.Perform()
+
+		// The record of the finished task is kept in the task store
+		s.tasksLock.Lock()
+		delete(s.tasks, id)
+		s.tasksLock.Unlock()
 	}()
 }
 
+// deleteExpiredTasks deletes the records of the tasks which finished before the given time.
+func (s *Server) deleteExpiredTasks(before time.Time) error {
+	records, err := s.Store.All()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if !record.Status.finishedBefore(before) {
+			continue
+		}
+
+		if err := s.Store.Delete(record.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneTasks periodically deletes the records of tasks which finished longer than the retention ago.
+func (s *Server) pruneTasks() {
+	retention := s.Config.TaskRetention.Duration
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(taskPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.deleteExpiredTasks(time.Now().Add(-retention)); err != nil {
+			log.Printf("couldn't delete expired tasks: %s\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// GetTaskSummary returns the summary of the task with the given id.
+// Tasks which aren't known to the running server are looked up in the task store.
+func (s *Server) GetTaskSummary(id uuid.UUID) (TaskSummary, error) {
+	s.tasksLock.RLock()
+	task, ok := s.tasks[id]
+	s.tasksLock.RUnlock()
+
+	if ok {
+		return task.GetSummary(), nil
+	}
+
+	record, err := s.Store.Get(id)
+	if err != nil {
+		return TaskSummary{}, err
+	}
+
+	return NewTaskSummary(record), nil
+}
+
+// ListTasks returns the records of all tasks known to the server.
+// The status of running tasks is up to date.
+func (s *Server) ListTasks() ([]TaskRecord, error) {
+	records, err := s.Store.All()
+	if err != nil {
+		return nil, err
+	}
+
+	s.tasksLock.RLock()
+	for i, record := range records {
+		if task, ok := s.tasks[record.Id]; ok {
+			records[i].Status = *task.GetStatus()
+		}
+	}
+	s.tasksLock.RUnlock()
+
+	return records, nil
+}
+
+// GetTask returns the running task with the given id.
+// Tasks which have finished before the server started aren't available.
+func (s *Server) GetTask(id uuid.UUID) (Task, error) {
+	s.tasksLock.RLock()
+	task, ok := s.tasks[id]
+	s.tasksLock.RUnlock()
+
+	if ok {
+		return task, nil
+	}
+
+	if _, err := s.Store.Get(id); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrTaskNotRunning
+}
+
+// restoreTasks resumes all unfinished tasks from the task store.
+func (s *Server) restoreTasks() error {
+	records, err := s.Store.All()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Status.Finished() {
+			continue
+		}
+
+		log.Printf("[%s] resuming task\n", record.Id)
+		s.PerformTask(RestoreDownloadTask(s, record))
+	}
+
+	return nil
+}
+
 func (s *Server) SendCallback(url string, data interface{}) (resp *http.Response, err error) {
 	p, err := json.Marshal(data)
 	if err != nil {
@@ -106,11 +401,134 @@ func (s *Server) GoSendCallback(url string, data interface{}) {
 	}()
 }
 
+// publishProgress periodically refreshes and publishes the progress of all running tasks.
+func (s *Server) publishProgress() {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		s.tasksLock.RLock()
+		tasks := make([]Task, 0, len(s.tasks))
+		for _, task := range s.tasks {
+			tasks = append(tasks, task)
+		}
+		s.tasksLock.RUnlock()
+
+		s.refreshProgress(tasks)
+
+		for _, task := range tasks {
+			summary := task.GetSummary()
+			if summary.Status.Running && summary.Status.Progress != nil {
+				s.Events.Publish(ProgressEvent, summary)
+			}
+		}
+	}
+}
+
+// refreshProgress updates the download progress of the tasks using a single request per backend.
+func (s *Server) refreshProgress(tasks []Task) {
+	batches := make(map[*Backend]*aria2.Batch)
+	apply := make(map[*Backend][]func())
+
+	for _, task := range tasks {
+		downloadTask, ok := task.(*downloadTask)
+		if !ok {
+			continue
+		}
+
+		backend := downloadTask.getBackend()
+		if backend == nil {
+			continue
+		}
+
+		batch, ok := batches[backend]
+		if !ok {
+			batch = backend.Client.Batch()
+			batches[backend] = batch
+		}
+
+		if fn := downloadTask.queueProgress(batch); fn != nil {
+			apply[backend] = append(apply[backend], fn)
+		}
+	}
+
+	for backend, batch := range batches {
+		if batch.Len() == 0 {
+			continue
+		}
+
+		if err := batch.Send(); err != nil {
+			log.Printf("couldn't refresh progress on %s: %s\n", backend.Name, err)
+			continue
+		}
+
+		for _, fn := range apply[backend] {
+			fn()
+		}
+	}
+}
+
+// failover moves the downloads of the dead backend to the other backends.
+func (s *Server) failover(backend *Backend) {
+	s.tasksLock.RLock()
+	defer s.tasksLock.RUnlock()
+
+	for _, task := range s.tasks {
+		if downloadTask, ok := task.(*downloadTask); ok {
+			downloadTask.failover(backend)
+		}
+	}
+}
+
+// backends lists the aria2 backends and their load.
+func (s *Server) backends(w http.ResponseWriter, r *http.Request) {
+	_ = jsonResponse(w, s.Backends.Statuses(), http.StatusOK)
+}
+
 func (s *Server) addHandlers() {
 	r := s.Router
+	// event streams are long-lived and mustn't time out
+	timeout := middleware.Timeout(60 * time.Second)
+
+	r.With(timeout).Get("/download", s.download)
+	r.With(timeout).Post("/download", s.downloadPost)
+	r.With(timeout).Get("/status", s.status)
+
+	r.With(timeout).Get("/tasks", s.listTasks)
+	r.With(timeout).Post("/tasks", s.createTask)
+	r.Route("/tasks/{id}", func(r chi.Router) {
+		r.Get("/events", s.taskEvents)
+
+		r.Group(func(r chi.Router) {
+			r.Use(timeout)
+
+			r.Delete("/", s.controlTask(Task.Cancel))
+			r.Post("/pause", s.controlTask(Task.Pause))
+			r.Post("/resume", s.controlTask(Task.Resume))
+		})
+	})
+	r.Get("/events", s.events)
+
+	r.With(timeout).Get("/backends", s.backends)
+
+	for _, target := range s.Config.storageTargets() {
+		storage, ok := s.Storages[target.Name].(servingStorage)
+		if !ok || !target.Local.Serve {
+			continue
+		}
 
-	r.Get("/download", s.download)
-	r.Get("/status", s.status)
+		prefix := "/files"
+		if len(s.Config.StorageTargets) > 0 {
+			prefix += "/" + target.Name
+		}
+		r.Mount(prefix, http.StripPrefix(prefix, storage.Handler()))
+	}
 }
 
 func jsonResponse(w http.ResponseWriter, data interface{}, status int) error {
@@ -119,21 +537,106 @@ func jsonResponse(w http.ResponseWriter, data interface{}, status int) error {
 	return json.NewEncoder(w).Encode(data)
 }
 
-func (s *Server) download(w http.ResponseWriter, r *http.Request) {
-	var downloadRequest DownloadRequest
-	err := schemaDecoder.Decode(&downloadRequest, r.URL.Query())
-	if err != nil {
-		http.Error(w, err.Error(), 400)
-		return
+// errorResponse writes the error as JSON.
+// Validation errors also contain the problems of the individual fields.
+func errorResponse(w http.ResponseWriter, err error, status int) {
+	resp := ErrorResponse{Error: err.Error()}
+	if validationErr, ok := err.(*ValidationError); ok {
+		resp.Error = "invalid request"
+		resp.Fields = validationErr.Fields
 	}
 
-	if err := downloadRequest.UseConfig(&s.Config); err != nil {
-		http.Error(w, err.Error(), 400)
-		return
+	_ = jsonResponse(w, resp, status)
+}
+
+// decodeQuery decodes the query parameters of the request into v.
+func decodeQuery(r *http.Request, v interface{}) error {
+	return decodeValues(r.URL.Query(), v)
+}
+
+// decodeValues decodes query or form values into v.
+func decodeValues(values url.Values, v interface{}) error {
+	err := schemaDecoder.Decode(v, values)
+
+	if multiErr, ok := err.(schema.MultiError); ok {
+		var errs ValidationError
+		for field, fieldErr := range multiErr {
+			errs.Add(field, "%s", fieldErr.Error())
+		}
+
+		sort.Slice(errs.Fields, func(i, j int) bool { return errs.Fields[i].Field < errs.Fields[j].Field })
+		return &errs
+	}
+
+	return err
+}
+
+// decodeJSON decodes the JSON body of the request into v.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		var errs ValidationError
+		errs.Add(typeErr.Field, "must be of type %s", typeErr.Type)
+		return &errs
+	}
+
+	// The decoder doesn't have a dedicated error type for unknown fields
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		var errs ValidationError
+		errs.Add(strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "unknown field")
+		return &errs
+	}
+
+	return err
+}
+
+// decodeDownloadRequest decodes the body of a download request.
+// The body is either JSON or a multipart form which may contain a torrent or metalink file.
+func decodeDownloadRequest(w http.ResponseWriter, r *http.Request, req *DownloadRequest) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return decodeJSON(w, r, req)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := r.ParseMultipartForm(maxRequestBodySize); err != nil {
+		return err
+	}
+
+	if err := decodeValues(r.MultipartForm.Value, req); err != nil {
+		return err
+	}
+
+	var err error
+	if req.Torrent, err = readFormFile(r, "torrent"); err != nil {
+		return err
+	}
+
+	req.Metalink, err = readFormFile(r, "metalink")
+	return err
+}
+
+// readFormFile reads the content of an uploaded file.
+// It returns nil if the file wasn't uploaded.
+func readFormFile(r *http.Request, field string) ([]byte, error) {
+	f, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
+	defer func() { _ = f.Close() }()
+
+	return ioutil.ReadAll(f)
+}
 
-	if err := downloadRequest.Check(); err != nil {
-		http.Error(w, err.Error(), 400)
+// startDownload validates the request and starts a new download task.
+func (s *Server) startDownload(w http.ResponseWriter, downloadRequest DownloadRequest, status int) {
+	if err := downloadRequest.Validate(&s.Config); err != nil {
+		errorResponse(w, err, 400)
 		return
 	}
 
@@ -141,22 +644,109 @@ func (s *Server) download(w http.ResponseWriter, r *http.Request) {
 	s.PerformTask(task)
 
 	resp := DownloadResponse{task.GetId().String()}
-	_ = jsonResponse(w, resp, http.StatusOK)
+	_ = jsonResponse(w, resp, status)
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	var downloadRequest DownloadRequest
+	if err := decodeQuery(r, &downloadRequest); err != nil {
+		errorResponse(w, err, 400)
+		return
+	}
+
+	s.startDownload(w, downloadRequest, http.StatusOK)
+}
+
+func (s *Server) downloadPost(w http.ResponseWriter, r *http.Request) {
+	var downloadRequest DownloadRequest
+	if err := decodeDownloadRequest(w, r, &downloadRequest); err != nil {
+		errorResponse(w, err, 400)
+		return
+	}
+
+	s.startDownload(w, downloadRequest, http.StatusOK)
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	var downloadRequest DownloadRequest
+	if err := decodeDownloadRequest(w, r, &downloadRequest); err != nil {
+		errorResponse(w, err, 400)
+		return
+	}
+
+	s.startDownload(w, downloadRequest, http.StatusCreated)
 }
 
 func (s *Server) status(w http.ResponseWriter, r *http.Request) {
 	rawID := r.URL.Query().Get("id")
 	id, err := uuid.Parse(rawID)
 	if err != nil {
-		http.Error(w, err.Error(), 400)
+		errorResponse(w, err, 400)
 		return
 	}
 
-	task, ok := s.tasks[id]
-	if !ok {
-		http.Error(w, "task not found", 404)
+	summary, err := s.GetTaskSummary(id)
+	if err == ErrTaskNotFound {
+		errorResponse(w, err, 404)
+		return
+	} else if err != nil {
+		errorResponse(w, err, 500)
+		return
+	}
+
+	_ = jsonResponse(w, summary.Status, http.StatusOK)
+}
+
+func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	var query TaskQuery
+	if err := decodeQuery(r, &query); err != nil {
+		errorResponse(w, err, 400)
+		return
+	}
+
+	if err := query.Check(); err != nil {
+		errorResponse(w, err, 400)
+		return
+	}
+
+	records, err := s.ListTasks()
+	if err != nil {
+		errorResponse(w, err, 500)
 		return
 	}
 
-	_ = jsonResponse(w, task.GetStatus(), http.StatusOK)
+	page, next := query.Apply(records)
+	resp := TaskListResponse{Tasks: make([]TaskSummary, len(page)), Next: next}
+	for i, record := range page {
+		resp.Tasks[i] = NewTaskSummary(record)
+	}
+
+	_ = jsonResponse(w, resp, http.StatusOK)
+}
+
+// controlTask returns a handler which performs the given action on the task denoted by the "id" url parameter.
+func (s *Server) controlTask(action func(task Task) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			errorResponse(w, err, 400)
+			return
+		}
+
+		task, err := s.GetTask(id)
+		if err == nil {
+			err = action(task)
+		}
+
+		switch err {
+		case nil:
+			_ = jsonResponse(w, task.GetSummary().Status, http.StatusOK)
+		case ErrTaskNotFound:
+			errorResponse(w, err, 404)
+		case ErrTaskNotRunning, ErrTaskNotPausable, ErrTaskNotPaused, ErrTaskNotAttached, ErrTaskStateChanged:
+			errorResponse(w, err, 409)
+		default:
+			errorResponse(w, err, 500)
+		}
+	}
 }
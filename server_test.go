@@ -0,0 +1,317 @@
+package arias
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAria2 is an aria2 rpc interface which serves the statuses of its downloads over HTTP.
+type fakeAria2 struct {
+	mu       sync.Mutex
+	statuses map[string]aria2.Status
+	calls    []string
+	lastGID  int
+	// down makes every call fail
+	down bool
+}
+
+type fakeAria2Request struct {
+	Id     uint64            `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type fakeAria2Call struct {
+	MethodName string            `json:"methodName"`
+	Params     []json.RawMessage `json:"params"`
+}
+
+// newFakeAria2 starts a fake aria2 and returns a client connected to it.
+func newFakeAria2(t *testing.T) (*fakeAria2, *aria2.Client) {
+	fake := &fakeAria2{statuses: make(map[string]aria2.Status)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req fakeAria2Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		res := map[string]interface{}{"id": req.Id, "jsonrpc": "2.0"}
+		if result, fault := fake.call(req.Method, req.Params); fault != nil {
+			res["error"] = fault
+		} else {
+			res["result"] = result
+		}
+
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := aria2.Dial(server.URL, aria2.WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return fake, client
+}
+
+// setStatus adds or replaces the download with the given status.
+func (f *fakeAria2) setStatus(status aria2.Status) {
+	f.mu.Lock()
+	f.statuses[status.GID] = status
+	f.mu.Unlock()
+}
+
+func (f *fakeAria2) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+// called returns the names of the methods which were called, excluding the ones used for polling.
+func (f *fakeAria2) called() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeAria2) call(method string, params []json.RawMessage) (interface{}, *aria2.Fault) {
+	if method == "system.multicall" {
+		var calls []fakeAria2Call
+		if err := json.Unmarshal(params[0], &calls); err != nil {
+			return nil, &aria2.Fault{Code: 1, Message: err.Error()}
+		}
+
+		results := make([]interface{}, len(calls))
+		for i, call := range calls {
+			if result, fault := f.call(call.MethodName, call.Params); fault != nil {
+				results[i] = fault
+			} else {
+				results[i] = []interface{}{result}
+			}
+		}
+
+		return results, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.down {
+		return nil, &aria2.Fault{Code: 1, Message: "unavailable"}
+	}
+
+	var gid string
+	if len(params) > 0 {
+		_ = json.Unmarshal(params[0], &gid)
+	}
+
+	switch method {
+	case "aria2.tellActive", "aria2.tellWaiting", "aria2.tellStopped":
+		return []aria2.Status{}, nil
+	case "aria2.getGlobalStat":
+		return aria2.GlobalStat{}, nil
+	}
+
+	f.calls = append(f.calls, method)
+
+	switch method {
+	case "aria2.addUri":
+		f.lastGID++
+		gid = fmt.Sprintf("%016x", f.lastGID)
+		f.statuses[gid] = aria2.Status{GID: gid, Status: aria2.StatusActive}
+		return gid, nil
+	case "aria2.tellStatus", "aria2.getUris":
+		status, ok := f.statuses[gid]
+		if !ok {
+			return nil, &aria2.Fault{Code: 1, Message: fmt.Sprintf("GID %s is not found", gid)}
+		}
+
+		if method == "aria2.getUris" {
+			return []aria2.URI{}, nil
+		}
+		return status, nil
+	case "aria2.remove", "aria2.forceRemove", "aria2.removeDownloadResult", "aria2.pause", "aria2.unpause":
+		if method == "aria2.removeDownloadResult" {
+			delete(f.statuses, gid)
+		}
+		return "OK", nil
+	}
+
+	return nil, &aria2.Fault{Code: 1, Message: "unknown method " + method}
+}
+
+// newTestServer creates a server which uploads to a local storage in a temporary directory.
+func newTestServer(t *testing.T, backends ...*Backend) *Server {
+	config := defaultConfig()
+	config.TaskStorePath = ""
+	config.StorageType = "local"
+	config.Local.Root = t.TempDir()
+	config.DefaultBucket = "anime"
+	config.AllowNoName = true
+
+	storages, err := newStorages(config)
+	require.NoError(t, err)
+
+	return &Server{
+		HttpClient: http.DefaultClient,
+		Config:     config,
+
+		Backends: NewBackendPool(backends),
+		Storages: storages,
+		Store:    NewMemoryTaskStore(),
+		Events:   NewEventHub(),
+
+		tasks: make(map[uuid.UUID]Task),
+		done:  make(chan struct{}),
+	}
+}
+
+func TestRestoreCompletedDownload(t *testing.T) {
+	fake, client := newFakeAria2(t)
+	s := newTestServer(t, NewBackend(defaultBackendName, client))
+
+	// The download finished while arias wasn't running
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ep1.mkv")
+	require.NoError(t, ioutil.WriteFile(file, []byte("episode"), 0644))
+	fake.setStatus(aria2.Status{
+		GID:    "2089b05ecca3d829",
+		Status: aria2.StatusCompleted,
+		Dir:    dir,
+		Files:  []aria2.File{{Index: 1, Path: file, Length: 7, CompletedLength: 7, Selected: true}},
+	})
+
+	id := uuid.New()
+	record := TaskRecord{
+		Id:      id,
+		Request: DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"},
+		GIDs:    []string{"2089b05ecca3d829"},
+		Status:  TaskStatus{Id: id.String(), Running: true, State: "downloading"},
+	}
+
+	task := RestoreDownloadTask(s, record)
+	assert.NoError(t, task.Perform())
+
+	status := task.GetStatus()
+	assert.Equal(t, "done", status.State)
+	assert.NotNil(t, status.FinishedAt)
+
+	content, err := ioutil.ReadFile(filepath.Join(s.Config.Local.Root, "anime", "ep1.mkv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "episode", string(content))
+
+	assert.NotContains(t, fake.called(), "aria2.addUri")
+	assert.Contains(t, fake.called(), "aria2.removeDownloadResult")
+}
+
+func TestDeleteExpiredTasks(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	finishedAt := now.Add(-time.Hour)
+
+	running := TaskRecord{Id: uuid.New(), Status: TaskStatus{Running: true, State: "downloading", CreatedAt: now.Add(-2 * time.Hour)}}
+	expired := TaskRecord{Id: uuid.New(), Status: TaskStatus{State: "done", FinishedAt: &finishedAt}}
+	// Records from before the finish time was stored expire by their creation time
+	legacy := TaskRecord{Id: uuid.New(), Status: TaskStatus{State: "error", CreatedAt: now.Add(-2 * time.Hour)}}
+	recent := TaskRecord{Id: uuid.New(), Status: TaskStatus{State: "done", FinishedAt: &now}}
+
+	for _, record := range []TaskRecord{running, expired, legacy, recent} {
+		require.NoError(t, s.Store.Put(record))
+	}
+
+	assert.NoError(t, s.deleteExpiredTasks(now.Add(-time.Minute)))
+
+	records, err := s.Store.All()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []TaskRecord{running, recent}, records)
+}
+
+func TestRestoreLegacyBackend(t *testing.T) {
+	_, client := newFakeAria2(t)
+	managed, other := NewBackend(managedBackendName, client), NewBackend(defaultBackendName, client)
+
+	// Records from before there were several backends don't have a backend
+	record := TaskRecord{
+		Id:      uuid.New(),
+		Request: DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"},
+		GIDs:    []string{"2089b05ecca3d829"},
+		Status:  TaskStatus{Running: true, State: "downloading"},
+	}
+
+	s := newTestServer(t, managed, other)
+	task := RestoreDownloadTask(s, record).(*downloadTask)
+	assert.Equal(t, other, task.backend)
+
+	s.Config.ManageAria2 = true
+	task = RestoreDownloadTask(s, record).(*downloadTask)
+	assert.Equal(t, managed, task.backend)
+	assert.Equal(t, []string{"2089b05ecca3d829"}, []string{task.gids[0].GID})
+}
+
+func TestErrorResponses(t *testing.T) {
+	s := newTestServer(t)
+	s.Router = chi.NewRouter()
+	s.addHandlers()
+
+	for target, status := range map[string]int{
+		"/status?id=ep1":                        http.StatusBadRequest,
+		"/status?id=" + uuid.NewString():        http.StatusNotFound,
+		"/tasks/" + uuid.NewString() + "/pause": http.StatusNotFound,
+		"/tasks/ep1/resume":                     http.StatusBadRequest,
+	} {
+		method := http.MethodGet
+		if strings.HasPrefix(target, "/tasks/") {
+			method = http.MethodPost
+		}
+
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		assert.Equal(t, status, rec.Code, target)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), target)
+
+		var resp ErrorResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), target)
+		assert.NotEmpty(t, resp.Error, target)
+	}
+}
+
+func TestControlFinishedTask(t *testing.T) {
+	s := &Server{Store: NewMemoryTaskStore(), tasks: make(map[uuid.UUID]Task)}
+	record := TaskRecord{Id: uuid.New(), Status: TaskStatus{State: "done"}}
+	require.NoError(t, s.Store.Put(record))
+
+	r := chi.NewRouter()
+	r.Delete("/tasks/{id}", s.controlTask(Task.Cancel))
+	r.Post("/tasks/{id}/pause", s.controlTask(Task.Pause))
+	r.Post("/tasks/{id}/resume", s.controlTask(Task.Resume))
+
+	// The task is only known to the store, so it can't be controlled anymore
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodDelete, "/tasks/"+record.Id.String(), nil),
+		httptest.NewRequest(http.MethodPost, "/tasks/"+record.Id.String()+"/pause", nil),
+		httptest.NewRequest(http.MethodPost, "/tasks/"+record.Id.String()+"/resume", nil),
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusConflict, rec.Code, req.URL.Path)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/tasks/"+uuid.New().String(), nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -4,14 +4,22 @@ import (
 	"cloud.google.com/go/storage"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/uuid"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"path"
 )
 
 type UploadOptions struct {
@@ -25,25 +33,91 @@ type UploadOptions struct {
 type UploadOutput struct {
 	Bucket   string `json:"bucket"`
 	Filename string `json:"filename"`
+	// Path is the location of the file on the server for storages which don't have buckets themselves
+	Path string `json:"path,omitempty"`
 }
 
 type Storage interface {
 	Upload(ctx context.Context, f io.ReadSeeker, options UploadOptions) (UploadOutput, error)
 }
 
-func NewStorageFromType(storageType string) (s Storage, err error) {
-	switch storageType {
+// tempUploadPrefix is the prefix of the files uploads are written to before they're renamed to their destination.
+// This way incomplete uploads never appear under their final name.
+const tempUploadPrefix = ".arias-upload-"
+
+// temporaryError marks an error of a storage as temporary or permanent for the retry policy.
+type temporaryError struct {
+	err       error
+	temporary bool
+}
+
+func (e *temporaryError) Error() string   { return e.err.Error() }
+func (e *temporaryError) Unwrap() error   { return e.err }
+func (e *temporaryError) Temporary() bool { return e.temporary }
+
+// httpStatusError attaches the HTTP status code of the failed request to an error of a storage,
+// the retry policy decides by it whether the error is temporary.
+// Errors of the AWS SDK already have a StatusCode method.
+type httpStatusError struct {
+	err    error
+	status int
+}
+
+func (e *httpStatusError) Error() string   { return e.err.Error() }
+func (e *httpStatusError) Unwrap() error   { return e.err }
+func (e *httpStatusError) StatusCode() int { return e.status }
+
+// servingStorage is a Storage which can serve the uploaded files itself.
+type servingStorage interface {
+	Storage
+	// Handler serves the file {bucket}/{filename} at the path /{bucket}/{filename}
+	Handler() http.Handler
+}
+
+// NewStorageFromTarget creates the storage of the target using the settings of its type.
+func NewStorageFromTarget(t StorageTarget) (s Storage, err error) {
+	switch t.Type {
 	case "google":
-		s, err = NewGoogleCloudStorage()
+		s, err = NewGoogleCloudStorage(t.Google.clientOptions()...)
 	case "s3":
-		s, err = NewS3Storage()
+		s, err = NewS3StorageFromConfig(t.S3)
+	case "local":
+		s, err = NewLocalStorage(t.Local.Root)
+	case "sftp":
+		s, err = NewSFTPStorage(t.SFTP)
+	case "ftp":
+		s, err = NewFTPStorage(t.FTP)
+	case "webdav":
+		s, err = NewWebDAVStorage(t.WebDAV)
 	default:
-		err = fmt.Errorf("unknown storage: %s", storageType)
+		err = fmt.Errorf("unknown storage: %s", t.Type)
+	}
+
+	return
+}
+
+// remotePath returns the path of the file on a server which uses the bucket as a directory below root.
+// Neither the bucket nor the filename can escape root.
+func remotePath(root string, options UploadOptions) (bucket, filename, p string, err error) {
+	bucket, err = sanitizeFilename(options.Bucket)
+	if err != nil {
+		return
+	}
+
+	filename, err = sanitizeFilename(options.Filename)
+	if err != nil {
+		return
 	}
 
+	p = path.Join(root, bucket, filename)
 	return
 }
 
+// remoteTempPath returns the path an upload to p is written to before it's renamed to p.
+func remoteTempPath(p string) string {
+	return path.Join(path.Dir(p), tempUploadPrefix+uuid.New().String())
+}
+
 func determineContentType(f io.ReadSeeker, options UploadOptions) (contentType string, err error) {
 	contentType = options.ContentType
 
@@ -65,6 +139,20 @@ func determineContentType(f io.ReadSeeker, options UploadOptions) (contentType s
 	return
 }
 
+// GoogleStorageConfig configures the "google" storage type.
+type GoogleStorageConfig struct {
+	// CredentialsFile is the key of a service account, the application default credentials are used if empty
+	CredentialsFile string
+}
+
+func (c GoogleStorageConfig) clientOptions() (opts []option.ClientOption) {
+	if c.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(c.CredentialsFile))
+	}
+
+	return
+}
+
 type googleCloudStorage struct {
 	ctx    context.Context
 	client *storage.Client
@@ -105,9 +193,15 @@ func (s *googleCloudStorage) Upload(ctx context.Context, f io.ReadSeeker, option
 	w, _ := gzip.NewWriterLevel(objWriter, gzip.BestCompression)
 	_, errWrite := io.Copy(w, f)
 	_ = w.Close()
-	_ = objWriter.Close()
+	// The upload is finished when the writer is closed, this is where the errors of the server appear
+	if errClose := objWriter.Close(); errWrite == nil {
+		errWrite = errClose
+	}
 
-	if errWrite != nil {
+	var googleErr *googleapi.Error
+	if errors.As(errWrite, &googleErr) {
+		return out, &httpStatusError{err: errWrite, status: googleErr.Code}
+	} else if errWrite != nil {
 		return out, errWrite
 	}
 
@@ -120,14 +214,113 @@ func (s *googleCloudStorage) Upload(ctx context.Context, f io.ReadSeeker, option
 	return out, nil
 }
 
+// S3StorageConfig configures the "s3" storage type.
+// It also supports S3 compatible services like MinIO, Ceph, Wasabi or R2.
+type S3StorageConfig struct {
+	// Endpoint is the url of the S3 compatible service, the AWS endpoint is used if empty
+	Endpoint string
+	Region   string
+	// ForcePathStyle uses http://endpoint/bucket/key instead of http://bucket.endpoint/key,
+	// most self-hosted services require it
+	ForcePathStyle bool
+
+	// Credentials determines where the credentials come from:
+	// "static" uses AccessKeyId and SecretAccessKey, "profile" the Profile of the CredentialsFile,
+	// "env" the AWS_* environment variables and "iam" the IAM role of the EC2 instance.
+	// If empty, the default chain of the AWS SDK is used.
+	Credentials     string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Profile         string
+	// CredentialsFile defaults to ~/.aws/credentials
+	CredentialsFile string
+
+	DisableSSL bool
+	// CAFile is a PEM encoded certificate bundle to verify the endpoint with
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// awsConfig creates the AWS config for the session.
+// Credentials which need a session are added by NewS3StorageFromConfig.
+func (c S3StorageConfig) awsConfig() (*aws.Config, error) {
+	cfg := aws.NewConfig().WithS3ForcePathStyle(c.ForcePathStyle).WithDisableSSL(c.DisableSSL)
+
+	if c.Endpoint != "" {
+		cfg = cfg.WithEndpoint(c.Endpoint)
+	}
+
+	if c.Region != "" {
+		cfg = cfg.WithRegion(c.Region)
+	}
+
+	switch c.Credentials {
+	case "":
+	case "static":
+		if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+			return nil, errors.New("static credentials require an access key id and a secret access key")
+		}
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(c.AccessKeyId, c.SecretAccessKey, c.SessionToken))
+	case "profile":
+		cfg = cfg.WithCredentials(credentials.NewSharedCredentials(c.CredentialsFile, c.Profile))
+	case "env":
+		cfg = cfg.WithCredentials(credentials.NewEnvCredentials())
+	case "iam":
+	default:
+		return nil, fmt.Errorf("unknown credentials: %s", c.Credentials)
+	}
+
+	if c.CAFile != "" || c.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+
+		if c.CAFile != "" {
+			pem, err := ioutil.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+			}
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		cfg = cfg.WithHTTPClient(&http.Client{Transport: transport})
+	}
+
+	return cfg, nil
+}
+
+// NewS3StorageFromConfig creates a S3 storage using the given config.
+func NewS3StorageFromConfig(c S3StorageConfig) (Storage, error) {
+	cfg, err := c.awsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Credentials == "iam" {
+		sess, err := session.NewSession(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg = cfg.WithCredentials(ec2rolecreds.NewCredentials(sess))
+	}
+
+	return NewS3Storage(cfg)
+}
+
 type s3Storage struct {
 	session  *session.Session
 	uploader *s3manager.Uploader
 }
 
+// NewS3Storage creates a S3 storage.
+// Unless the options contain credentials, the default chain of the AWS SDK is used to find them.
 func NewS3Storage(opts ...*aws.Config) (s Storage, err error) {
-	opt := aws.NewConfig().WithCredentials(credentials.NewEnvCredentials())
-	opts = append([]*aws.Config{opt}, opts...)
 	sess, err := session.NewSession(opts...)
 	if err != nil {
 		return
@@ -177,7 +370,7 @@ func (s *s3Storage) Upload(ctx context.Context, f io.ReadSeeker, options UploadO
 	}
 	_, err = s.uploader.UploadWithContext(ctx, &input)
 
-	out = UploadOutput{options.Bucket, options.Filename}
+	out = UploadOutput{Bucket: options.Bucket, Filename: options.Filename}
 
 	return
 }
@@ -0,0 +1,137 @@
+package arias
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/jlaffaye/ftp"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+)
+
+// FTPStorageConfig configures the storage which uploads the files to a FTP server.
+type FTPStorageConfig struct {
+	// Addr is the host:port of the server, the port defaults to 21
+	Addr     string
+	User     string
+	Password string
+
+	// TLS is either "explicit" (AUTH TLS) or "implicit" to use FTPS, plain FTP is used if empty
+	TLS                string
+	InsecureSkipVerify bool
+
+	// Root is the directory containing the buckets, relative paths start at the login directory
+	Root string
+}
+
+type ftpStorage struct {
+	addr     string
+	user     string
+	password string
+	root     string
+	options  []ftp.DialOption
+}
+
+// NewFTPStorage creates a storage which uploads the files to {root}/{bucket}/{filename} on a FTP server.
+// A new connection is used for every upload.
+func NewFTPStorage(c FTPStorageConfig) (s Storage, err error) {
+	if c.Addr == "" {
+		return nil, errors.New("address of the ftp server must be specified")
+	}
+
+	addr := c.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "21")
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: c.InsecureSkipVerify}
+
+	options := []ftp.DialOption{ftp.DialWithTimeout(30 * time.Second)}
+	switch c.TLS {
+	case "":
+	case "explicit":
+		options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+	case "implicit":
+		options = append(options, ftp.DialWithTLS(tlsConfig))
+	default:
+		return nil, fmt.Errorf("unknown tls mode: %s", c.TLS)
+	}
+
+	user := c.User
+	if user == "" {
+		user = "anonymous"
+	}
+
+	s = &ftpStorage{addr: addr, user: user, password: c.Password, root: c.Root, options: options}
+	return
+}
+
+// mkdirAll creates the directory and all of its parents.
+// FTP has no way to tell whether a directory already exists,
+// so errors are ignored and surface when the file is stored.
+func mkdirAll(conn *ftp.ServerConn, dir string) {
+	var current string
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+
+		current = path.Join(current, part)
+		_ = conn.MakeDir(current)
+	}
+}
+
+// ftpError marks the errors which a FTP server reports with a 4xx code as temporary.
+func ftpError(err error) error {
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		return &temporaryError{err: err, temporary: replyErr.Code >= 400 && replyErr.Code < 500}
+	}
+
+	return err
+}
+
+func (s *ftpStorage) Upload(ctx context.Context, f io.ReadSeeker, options UploadOptions) (out UploadOutput, err error) {
+	defer func() { err = ftpError(err) }()
+
+	bucket, filename, dst, err := remotePath(s.root, options)
+	if err != nil {
+		return
+	}
+
+	conn, err := ftp.Dial(s.addr, append(s.options, ftp.DialWithContext(ctx))...)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Quit() }()
+
+	if err = conn.Login(s.user, s.password); err != nil {
+		return
+	}
+
+	mkdirAll(conn, path.Dir(dst))
+
+	tmp := remoteTempPath(dst)
+	if err = conn.Stor(tmp, &contextReader{ctx: ctx, r: f}); err != nil {
+		_ = conn.Delete(tmp)
+		return
+	}
+
+	if err = conn.Rename(tmp, dst); err != nil {
+		_ = conn.Delete(tmp)
+		return
+	}
+
+	out = UploadOutput{Bucket: bucket, Filename: filename, Path: dst}
+	return
+}
@@ -0,0 +1,209 @@
+package arias
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate creates a certificate for 127.0.0.1 which isn't signed by a trusted authority.
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveFTP starts a minimal FTP server which serves the given directory
+// and supports just enough commands for uploads.
+// tlsMode is used like FTPStorageConfig.TLS, the server refuses to log in without TLS if it's set.
+func serveFTP(t *testing.T, root, tlsMode string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	var tlsConfig *tls.Config
+	if tlsMode != "" {
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}}
+	}
+	if tlsMode == "implicit" {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveFTPConn(conn, root, tlsConfig, tlsMode == "implicit")
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveFTPConn(conn net.Conn, root string, tlsConfig *tls.Config, secure bool) {
+	defer func() { _ = conn.Close() }()
+
+	reply := func(format string, args ...interface{}) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	local := func(p string) string {
+		return filepath.Join(root, filepath.FromSlash(p))
+	}
+
+	var data net.Listener
+	var renameFrom string
+	// protected makes the data connections use TLS
+	var protected bool
+	reply("220 ready")
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		cmd, arg := scanner.Text(), ""
+		if i := strings.IndexByte(cmd, ' '); i >= 0 {
+			cmd, arg = cmd[:i], cmd[i+1:]
+		}
+
+		switch cmd {
+		case "AUTH":
+			if tlsConfig == nil || secure {
+				reply("502 not implemented")
+				continue
+			}
+
+			reply("234 ready")
+			conn = tls.Server(conn, tlsConfig)
+			scanner = bufio.NewScanner(conn)
+			secure = true
+		case "PBSZ":
+			reply("200 ok")
+		case "PROT":
+			protected = arg == "P"
+			reply("200 ok")
+		case "USER":
+			if tlsConfig != nil && !secure {
+				reply("530 TLS required")
+			} else {
+				reply("331 password required")
+			}
+		case "PASS":
+			if arg == "secret" {
+				reply("230 logged in")
+			} else {
+				reply("530 login incorrect")
+			}
+		case "TYPE":
+			reply("200 ok")
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "MKD":
+			if err := os.Mkdir(local(arg), 0755); err != nil {
+				reply("550 %s", err)
+			} else {
+				reply("257 created")
+			}
+		case "STOR":
+			f, err := os.Create(local(arg))
+			if err != nil {
+				reply("553 %s", err)
+				continue
+			}
+
+			reply("150 ok")
+			dataConn, err := data.Accept()
+			if err == nil {
+				if protected {
+					dataConn = tls.Server(dataConn, tlsConfig)
+				}
+				_, _ = io.Copy(f, dataConn)
+				_ = dataConn.Close()
+			}
+			_ = data.Close()
+			_ = f.Close()
+			reply("226 done")
+		case "RNFR":
+			renameFrom = arg
+			reply("350 ready")
+		case "RNTO":
+			if err := os.Rename(local(renameFrom), local(arg)); err != nil {
+				reply("550 %s", err)
+			} else {
+				reply("250 renamed")
+			}
+		case "DELE":
+			_ = os.Remove(local(arg))
+			reply("250 deleted")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestFTPStorage(t *testing.T) {
+	root := t.TempDir()
+	addr := serveFTP(t, root, "")
+
+	s, err := NewFTPStorage(FTPStorageConfig{Addr: addr, User: "arias", Password: "secret", Root: "/media"})
+	require.NoError(t, err)
+	testStorage(t, s, filepath.Join(root, "media"), "/media")
+
+	s, err = NewFTPStorage(FTPStorageConfig{Addr: addr, User: "arias", Password: "wrong"})
+	require.NoError(t, err)
+	_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Bucket: "anime", Filename: "ep2.mkv"})
+	assert.Error(t, err)
+
+	_, err = NewFTPStorage(FTPStorageConfig{Addr: addr, TLS: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestFTPStorageTLS(t *testing.T) {
+	for _, mode := range []string{"explicit", "implicit"} {
+		t.Run(mode, func(t *testing.T) {
+			root := t.TempDir()
+			addr := serveFTP(t, root, mode)
+			config := FTPStorageConfig{Addr: addr, User: "arias", Password: "secret", TLS: mode, InsecureSkipVerify: true, Root: "/media"}
+
+			s, err := NewFTPStorage(config)
+			require.NoError(t, err)
+			testStorage(t, s, filepath.Join(root, "media"), "/media")
+
+			// The certificate of the server is verified
+			config.InsecureSkipVerify = false
+			s, err = NewFTPStorage(config)
+			require.NoError(t, err)
+			_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Bucket: "anime", Filename: "ep2.mkv"})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package arias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorageConfig configures the storage which writes the uploads to the local filesystem.
+type LocalStorageConfig struct {
+	// Root is the directory containing the buckets, every bucket is a directory
+	Root string
+	// Serve makes the files available at /files/{bucket}/{filename},
+	// or /files/{target}/{bucket}/{filename} if Config.StorageTargets is used
+	Serve bool
+}
+
+type localStorage struct {
+	root string
+}
+
+// NewLocalStorage creates a storage which writes the uploads to directories under root.
+func NewLocalStorage(root string) (s Storage, err error) {
+	if root == "" {
+		return nil, errors.New("root of the local storage must be specified")
+	}
+
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return
+	}
+
+	s = &localStorage{root: root}
+	return
+}
+
+// sanitizeBucket makes sure the bucket is a single path element.
+func sanitizeBucket(bucket string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("invalid bucket: %q", bucket)
+	}
+
+	return bucket, nil
+}
+
+// sanitizeFilename cleans the slash separated filename so that it can't escape its bucket.
+func sanitizeFilename(filename string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(filename, `\`, "/")), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("invalid filename: %q", filename)
+	}
+
+	return cleaned, nil
+}
+
+func (s *localStorage) Upload(ctx context.Context, f io.ReadSeeker, options UploadOptions) (out UploadOutput, err error) {
+	bucket, err := sanitizeBucket(options.Bucket)
+	if err != nil {
+		return
+	}
+
+	filename, err := sanitizeFilename(options.Filename)
+	if err != nil {
+		return
+	}
+
+	dst := filepath.Join(s.root, bucket, filepath.FromSlash(filename))
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), tempUploadPrefix+"*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = io.Copy(tmp, &contextReader{ctx: ctx, r: f})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return
+	}
+
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return
+	}
+
+	out = UploadOutput{Bucket: bucket, Filename: filename}
+	return
+}
+
+// Handler serves the files of all buckets, directories aren't listed.
+func (s *localStorage) Handler() http.Handler {
+	return http.FileServer(noDirFileSystem{http.Dir(s.root)})
+}
+
+// noDirFileSystem is a http.FileSystem which doesn't open directories or uploads in progress.
+type noDirFileSystem struct {
+	fs http.FileSystem
+}
+
+func (fs noDirFileSystem) Open(name string) (http.File, error) {
+	if strings.HasPrefix(path.Base(name), tempUploadPrefix) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := fs.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err == nil && stat.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
@@ -0,0 +1,56 @@
+package arias
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"Show/ep1.mkv":         "Show/ep1.mkv",
+		"../../etc/passwd":     "etc/passwd",
+		"/Show/./../ep1.mkv":   "ep1.mkv",
+		`Show\..\..\ep1.mkv`:   "ep1.mkv",
+		"Show//Extras/op.webm": "Show/Extras/op.webm",
+	} {
+		sanitized, err := sanitizeFilename(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, sanitized, filename)
+	}
+
+	_, err := sanitizeFilename("../")
+	assert.Error(t, err)
+
+	_, err = sanitizeBucket("../anime")
+	assert.Error(t, err)
+}
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewLocalStorage(root)
+	require.NoError(t, err)
+
+	testStorage(t, storage, root, "")
+
+	handler := storage.(servingStorage).Handler()
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/anime/Show/ep1.mkv")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "episode v2", rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/anime/Show/").Code)
+
+	// Uploads in progress aren't served
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "anime", "Show", tempUploadPrefix+"123"), []byte("epi"), 0644))
+	assert.Equal(t, http.StatusNotFound, get("/anime/Show/"+tempUploadPrefix+"123").Code)
+}
@@ -0,0 +1,205 @@
+package arias
+
+import (
+	"context"
+	"errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// SFTPStorageConfig configures the storage which uploads the files to a SFTP server.
+type SFTPStorageConfig struct {
+	// Addr is the host:port of the server, the port defaults to 22
+	Addr string
+	User string
+
+	// Password and PrivateKey (path of a PEM encoded key) are used to authenticate,
+	// at least one of them must be specified
+	Password   string
+	PrivateKey string
+	// Passphrase decrypts the private key
+	Passphrase string
+
+	// KnownHosts is the path of the known_hosts file used to verify the server, defaults to ~/.ssh/known_hosts
+	KnownHosts string
+	// InsecureIgnoreHostKey disables the verification of the server
+	InsecureIgnoreHostKey bool
+
+	// Root is the directory containing the buckets, relative paths start at the home directory of the user
+	Root string
+}
+
+// clientConfig creates the ssh config from the settings.
+func (c SFTPStorageConfig) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+
+	if c.PrivateKey != "" {
+		pem, err := ioutil.ReadFile(c.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var signer ssh.Signer
+		if c.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(c.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+
+	if len(auth) == 0 {
+		return nil, errors.New("sftp storage requires a password or a private key")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if c.InsecureIgnoreHostKey {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile := c.KnownHosts
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+
+		var err error
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+type sftpStorage struct {
+	addr   string
+	root   string
+	config *ssh.ClientConfig
+}
+
+// NewSFTPStorage creates a storage which uploads the files to {root}/{bucket}/{filename} on a SFTP server.
+// A new connection is used for every upload.
+func NewSFTPStorage(c SFTPStorageConfig) (s Storage, err error) {
+	if c.Addr == "" {
+		return nil, errors.New("address of the sftp server must be specified")
+	}
+
+	config, err := c.clientConfig()
+	if err != nil {
+		return
+	}
+
+	addr := c.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	s = &sftpStorage{addr: addr, root: c.Root, config: config}
+	return
+}
+
+func (s *sftpStorage) dial(ctx context.Context) (*ssh.Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.addr, s.config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+func (s *sftpStorage) Upload(ctx context.Context, f io.ReadSeeker, options UploadOptions) (out UploadOutput, err error) {
+	bucket, filename, dst, err := remotePath(s.root, options)
+	if err != nil {
+		return
+	}
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Closing the connection aborts the upload if the context is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return
+	}
+	defer func() { _ = client.Close() }()
+
+	if err = client.MkdirAll(path.Dir(dst)); err != nil {
+		return
+	}
+
+	tmp := remoteTempPath(dst)
+	remote, err := client.Create(tmp)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = client.Remove(tmp)
+		}
+	}()
+
+	_, err = io.Copy(remote, &contextReader{ctx: ctx, r: f})
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	if err = client.PosixRename(tmp, dst); err != nil {
+		// Without the posix-rename extension the destination can't be replaced
+		_ = client.Remove(dst)
+		if err = client.Rename(tmp, dst); err != nil {
+			return
+		}
+	}
+
+	out = UploadOutput{Bucket: bucket, Filename: filename, Path: dst}
+	return
+}
@@ -0,0 +1,105 @@
+package arias
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveSFTP starts a SFTP server which accepts the password "secret" and returns its address and host key.
+func serveSFTP(t *testing.T) (string, ssh.PublicKey) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSSHConn(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func serveSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := sftp.NewServer(channel)
+				if err == nil {
+					_ = server.Serve()
+				}
+				_ = channel.Close()
+			}
+		}()
+	}
+}
+
+func TestSFTPStorage(t *testing.T) {
+	addr, hostKey := serveSFTP(t)
+	dir := t.TempDir()
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	require.NoError(t, ioutil.WriteFile(knownHostsFile, []byte(line+"\n"), 0600))
+
+	root := filepath.Join(dir, "remote")
+	config := SFTPStorageConfig{Addr: addr, User: "arias", Password: "secret", KnownHosts: knownHostsFile, Root: root}
+	s, err := NewSFTPStorage(config)
+	require.NoError(t, err)
+	testStorage(t, s, root, root)
+
+	// An unknown host key is rejected
+	require.NoError(t, ioutil.WriteFile(knownHostsFile, nil, 0600))
+	s, err = NewSFTPStorage(config)
+	require.NoError(t, err)
+	_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Bucket: "anime", Filename: "ep2.mkv"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package arias
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStorage checks the behaviour shared by the storages which write every bucket to a directory in dir.
+// pathPrefix is the expected prefix of UploadOutput.Path, it's empty for the storages without paths.
+func testStorage(t *testing.T, s Storage, dir, pathPrefix string) {
+	upload := func(content, filename string) UploadOutput {
+		out, err := s.Upload(context.Background(), strings.NewReader(content), UploadOptions{Bucket: "anime", Filename: filename})
+		require.NoError(t, err)
+		return out
+	}
+	read := func() string {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "anime", "Show", "ep1.mkv"))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	// The filename can't escape the bucket
+	expected := UploadOutput{Bucket: "anime", Filename: "Show/ep1.mkv"}
+	if pathPrefix != "" {
+		expected.Path = pathPrefix + "/anime/Show/ep1.mkv"
+	}
+	assert.Equal(t, expected, upload("episode", "../Show/ep1.mkv"))
+	assert.Equal(t, "episode", read())
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "anime", "Show"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary file left behind")
+
+	// Uploading again replaces the file
+	upload("episode v2", "Show/ep1.mkv")
+	assert.Equal(t, "episode v2", read())
+
+	_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Filename: "ep2.mkv"})
+	assert.Error(t, err, "the bucket is required")
+}
+
+func TestS3StorageConfig(t *testing.T) {
+	_, err := S3StorageConfig{Endpoint: "http://localhost:9000", ForcePathStyle: true}.awsConfig()
+	assert.NoError(t, err)
+
+	_, err = S3StorageConfig{Credentials: "static", AccessKeyId: "minio"}.awsConfig()
+	assert.Error(t, err)
+
+	_, err = S3StorageConfig{Credentials: "vault"}.awsConfig()
+	assert.Error(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caFile, []byte("not a certificate"), 0600))
+	_, err = S3StorageConfig{CAFile: caFile}.awsConfig()
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package arias
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/studio-b12/gowebdav"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// WebDAVStorageConfig configures the storage which uploads the files to a WebDAV server like Nextcloud.
+type WebDAVStorageConfig struct {
+	// URL is the collection containing the buckets,
+	// e.g. https://cloud.example.com/remote.php/dav/files/{user}/ for Nextcloud
+	URL string
+	// User and Password are used for basic or digest authentication, whichever the server asks for
+	User     string
+	Password string
+
+	InsecureSkipVerify bool
+}
+
+type webDAVStorage struct {
+	url       *url.URL
+	auth      gowebdav.Authorizer
+	transport http.RoundTripper
+}
+
+// NewWebDAVStorage creates a storage which uploads the files to {url}/{bucket}/{filename}.
+// Missing collections are created.
+func NewWebDAVStorage(c WebDAVStorageConfig) (s Storage, err error) {
+	if c.URL == "" {
+		return nil, errors.New("url of the webdav server must be specified")
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	// The authorizer remembers the authentication method of the server across uploads
+	s = &webDAVStorage{url: u, auth: requestURIAuthorizer{gowebdav.NewAutoAuth(c.User, c.Password)}, transport: transport}
+	return
+}
+
+func (s *webDAVStorage) Upload(ctx context.Context, f io.ReadSeeker, options UploadOptions) (out UploadOutput, err error) {
+	bucket, filename, p, err := remotePath("/", options)
+	if err != nil {
+		return
+	}
+
+	contentType, err := determineContentType(f, options)
+	if err != nil {
+		return
+	}
+
+	// A client is created for every upload because its settings apply to all of its requests
+	client := gowebdav.NewAuthClient(s.url.String(), s.auth)
+	client.SetTransport(&contextTransport{ctx: ctx, transport: s.transport})
+	client.SetInterceptor(func(method string, req *http.Request) {
+		if method == http.MethodPut {
+			req.Header.Set("Content-Type", contentType)
+		}
+	})
+
+	// The reader must stay seekable, otherwise the client buffers it in case the request has to be repeated
+	if err = client.WriteStream(p, f, 0644); err != nil {
+		var statusErr gowebdav.StatusError
+		if errors.As(err, &statusErr) {
+			err = &httpStatusError{err: err, status: statusErr.Status}
+		}
+		return
+	}
+
+	fileUrl := *s.url
+	fileUrl.Path = path.Join(fileUrl.Path, p)
+	out = UploadOutput{Bucket: bucket, Filename: filename, Path: fileUrl.String()}
+	return
+}
+
+// requestURIAuthorizer authorizes the requests for their full path.
+// gowebdav passes the path relative to the url of the client, which breaks digest authentication
+// because the server compares it to the path of the request.
+type requestURIAuthorizer struct {
+	gowebdav.Authorizer
+}
+
+func (a requestURIAuthorizer) NewAuthenticator(body io.Reader) (gowebdav.Authenticator, io.Reader) {
+	auth, body := a.Authorizer.NewAuthenticator(body)
+	return requestURIAuthenticator{auth}, body
+}
+
+type requestURIAuthenticator struct {
+	gowebdav.Authenticator
+}
+
+func (a requestURIAuthenticator) Authorize(c *http.Client, req *http.Request, _ string) error {
+	return a.Authenticator.Authorize(c, req, req.URL.RequestURI())
+}
+
+func (a requestURIAuthenticator) Clone() gowebdav.Authenticator {
+	return requestURIAuthenticator{a.Authenticator.Clone()}
+}
+
+// contextTransport makes the requests of a http.Client use the context.
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req.WithContext(t.ctx))
+}
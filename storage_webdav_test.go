@@ -0,0 +1,164 @@
+package arias
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const webDAVNonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+
+// fakeWebDAV is a WebDAV server which stores the files below a directory
+// and accepts the user "arias" with the password "secret".
+type fakeWebDAV struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	contentTypes map[string]string
+}
+
+// serveWebDAV starts a WebDAV server at {url}/dav/ which uses the authentication scheme "basic" or "digest".
+func serveWebDAV(t *testing.T, root, scheme string) *fakeWebDAV {
+	dav := &fakeWebDAV{contentTypes: make(map[string]string)}
+
+	dav.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scheme == "digest" && !checkDigestAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="arias", nonce="`+webDAVNonce+`", qop="auth", algorithm=MD5`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); scheme == "basic" && (!ok || user != "arias" || password != "secret") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="arias"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		local := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(r.URL.Path, "/dav")))
+		if _, err := os.Stat(filepath.Dir(local)); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		switch r.Method {
+		case "MKCOL":
+			if err := os.Mkdir(local, 0755); os.IsExist(err) {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			} else if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			} else {
+				w.WriteHeader(http.StatusCreated)
+			}
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			if err := ioutil.WriteFile(local, data, 0644); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			dav.mu.Lock()
+			dav.contentTypes[r.URL.Path] = r.Header.Get("Content-Type")
+			dav.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(dav.Close)
+
+	return dav
+}
+
+// checkDigestAuth verifies the digest authorization (RFC 2617, qop=auth) of the request.
+func checkDigestAuth(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Digest ") {
+		return false
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(header, "Digest "), ", ") {
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			params[param[:i]] = strings.Trim(param[i+1:], `"`)
+		}
+	}
+
+	hash := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := hash("arias:arias:secret")
+	ha2 := hash(r.Method + ":" + params["uri"])
+	response := hash(strings.Join([]string{ha1, webDAVNonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+
+	return params["username"] == "arias" && params["nonce"] == webDAVNonce && params["uri"] == r.URL.RequestURI() &&
+		params["qop"] == "auth" && params["response"] == response
+}
+
+func (dav *fakeWebDAV) contentType(p string) string {
+	dav.mu.Lock()
+	defer dav.mu.Unlock()
+
+	return dav.contentTypes[p]
+}
+
+func TestWebDAVStorage(t *testing.T) {
+	root := t.TempDir()
+	dav := serveWebDAV(t, root, "basic")
+
+	s, err := NewWebDAVStorage(WebDAVStorageConfig{URL: dav.URL + "/dav/", User: "arias", Password: "secret"})
+	require.NoError(t, err)
+	testStorage(t, s, root, dav.URL+"/dav")
+
+	_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Bucket: "anime", Filename: "Show/ep1.mkv", ContentType: "video/x-matroska"})
+	require.NoError(t, err)
+	assert.Equal(t, "video/x-matroska", dav.contentType("/dav/anime/Show/ep1.mkv"))
+
+	// The content type is detected if it isn't specified
+	_, err = s.Upload(context.Background(), strings.NewReader("<html></html>"), UploadOptions{Bucket: "anime", Filename: "Show/index.html"})
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", dav.contentType("/dav/anime/Show/index.html"))
+
+	s, err = NewWebDAVStorage(WebDAVStorageConfig{URL: dav.URL + "/dav/", User: "arias", Password: "wrong"})
+	require.NoError(t, err)
+	_, err = s.Upload(context.Background(), io.NewSectionReader(strings.NewReader("episode"), 0, 7), UploadOptions{Bucket: "anime", Filename: "ep2.mkv"})
+	assert.Error(t, err)
+}
+
+func TestWebDAVStorageDigestAuth(t *testing.T) {
+	root := t.TempDir()
+	dav := serveWebDAV(t, root, "digest")
+
+	s, err := NewWebDAVStorage(WebDAVStorageConfig{URL: dav.URL + "/dav/", User: "arias", Password: "secret"})
+	require.NoError(t, err)
+	testStorage(t, s, root, dav.URL+"/dav")
+
+	s, err = NewWebDAVStorage(WebDAVStorageConfig{URL: dav.URL + "/dav/", User: "arias", Password: "wrong"})
+	require.NoError(t, err)
+	_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Bucket: "anime", Filename: "ep2.mkv"})
+	assert.Error(t, err)
+}
+
+func TestWebDAVStorageServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s, err := NewWebDAVStorage(WebDAVStorageConfig{URL: server.URL})
+	require.NoError(t, err)
+
+	_, err = s.Upload(context.Background(), strings.NewReader("episode"), UploadOptions{Bucket: "anime", Filename: "ep1.mkv"})
+	assert.Error(t, err)
+	assert.True(t, isRetryable(err), "the server might be available again later")
+}
@@ -0,0 +1,208 @@
+package arias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// heartbeatInterval is the interval at which comments or pings are sent to keep idle event streams alive.
+	heartbeatInterval = 15 * time.Second
+	// pongTimeout is the time after which a websocket client which didn't answer the pings is disconnected.
+	pongTimeout = 3 * heartbeatInterval
+	// writeTimeout is the time a websocket client has to receive a message.
+	writeTimeout = 10 * time.Second
+)
+
+// checkOrigin reports whether the web page which opened the websocket is allowed to do so.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || containsString(s.Config.AllowedOrigins, "*") || containsString(s.Config.AllowedOrigins, origin) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// lastEventId returns the id of the last event the client received.
+// Browsers send it in the Last-Event-ID header when reconnecting to an event stream,
+// other clients may use the lastEventId query parameter instead.
+func lastEventId(r *http.Request) uint64 {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("lastEventId")
+	}
+
+	id, _ := strconv.ParseUint(raw, 10, 64)
+	return id
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event TaskEvent) error {
+	data, err := json.Marshal(event.Task.Status)
+	if err != nil {
+		return err
+	}
+
+	if event.Id != 0 {
+		_, _ = fmt.Fprintf(w, "id: %d\n", event.Id)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// taskEvents streams the events of a single task as server-sent events.
+// The stream ends once the task has finished.
+func (s *Server) taskEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		errorResponse(w, err, 400)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorResponse(w, errors.New("streaming not supported"), 500)
+		return
+	}
+
+	lastId := lastEventId(r)
+	sub, backlog := s.Events.Subscribe(id, lastId)
+	defer sub.Close()
+
+	summary, err := s.GetTaskSummary(id)
+	if err == ErrTaskNotFound {
+		errorResponse(w, err, 404)
+		return
+	} else if err != nil {
+		errorResponse(w, err, 500)
+		return
+	}
+
+	// Without a previous event the client needs to know the current state
+	if lastId == 0 {
+		backlog = []TaskEvent{{Type: StateEvent, Task: summary}}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if writeServerSentEvent(w, event) != nil || event.Task.Status.Finished() {
+			flusher.Flush()
+			return
+		}
+	}
+
+	// The final event of a finished task may have dropped out of the history
+	if summary.Status.Finished() {
+		_ = writeServerSentEvent(w, TaskEvent{Type: StateEvent, Task: summary})
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+
+			err := writeServerSentEvent(w, event)
+			flusher.Flush()
+
+			if err != nil || (event.Type == StateEvent && event.Task.Status.Finished()) {
+				return
+			}
+		case <-heartbeat.C:
+			_, _ = fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// events streams the events of all tasks over a websocket connection.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	lastId := lastEventId(r)
+
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Clients which stop answering the pings are disconnected
+	_ = conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	writeJSON := func(v interface{}) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return conn.WriteJSON(v)
+	}
+
+	sub, backlog := s.Events.Subscribe(uuid.Nil, lastId)
+	defer sub.Close()
+
+	// The client isn't expected to send anything,
+	// but reading is required to process control messages and to detect disconnects.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range backlog {
+		if err := writeJSON(event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events:
+			if !ok {
+				// The subscriber was too slow, it should reconnect with the last event id
+				msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout))
+				return
+			}
+
+			if err := writeJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package arias
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	s := &Server{Config: Config{AllowedOrigins: []string{"https://dashboard.example.org"}}}
+
+	for origin, allowed := range map[string]bool{
+		"":                              true,
+		"https://dashboard.example.org": true,
+		"http://arias.example.org:7200": true,
+		"https://evil.example.org":      false,
+		"http://arias.example.org":      false,
+	} {
+		r := httptest.NewRequest("GET", "http://arias.example.org:7200/events", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+
+		assert.Equal(t, allowed, s.checkOrigin(r), origin)
+	}
+
+	s.Config.AllowedOrigins = []string{"*"}
+	r := httptest.NewRequest("GET", "http://arias.example.org:7200/events", nil)
+	r.Header.Set("Origin", "https://evil.example.org")
+	assert.True(t, s.checkOrigin(r))
+}
+
+func TestTaskEventsFinished(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	record := TaskRecord{Id: uuid.New(), Status: TaskStatus{State: "done", FinishedAt: &now}}
+	require.NoError(t, s.Store.Put(record))
+
+	// The final event of the task isn't in the history anymore
+	s.Events.Publish(StateEvent, TaskSummary{Id: uuid.New(), Status: TaskStatus{State: "downloading"}})
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", record.Id.String())
+	r := httptest.NewRequest(http.MethodGet, "/tasks/"+record.Id.String()+"/events", nil)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	r.Header.Set("Last-Event-ID", strconv.FormatUint(s.Events.lastId, 10))
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.taskEvents(rec, r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream of a finished task wasn't closed")
+	}
+
+	assert.Contains(t, rec.Body.String(), "event: state\n")
+	assert.Contains(t, rec.Body.String(), `"state":"done"`)
+}
+
+func TestEventsRedacted(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(http.HandlerFunc(s.events))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	task := NewDownloadTask(s, DownloadRequest{Url: "https://cdn.example.org/ep1.mkv?token=secret", Bucket: "anime"}).(*downloadTask)
+	task.setURIs([]aria2.URI{{URI: "https://cdn.example.org/ep1.mkv?token=secret", Status: aria2.URIUsed}})
+
+	// The subscription is made once the connection is established
+	require.Eventually(t, func() bool {
+		s.Events.mu.Lock()
+		defer s.Events.mu.Unlock()
+		return len(s.Events.subs) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	task.commit()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, "cdn.example.org", event["task"].(map[string]interface{})["host"])
+}
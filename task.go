@@ -2,6 +2,7 @@ package arias
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/MyAnimeStream/arias/aria2"
@@ -10,25 +11,63 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTaskNotRunning is returned when trying to control a task which has already finished.
+	ErrTaskNotRunning = errors.New("task isn't running")
+	// ErrTaskNotPausable is returned when trying to pause a task which isn't downloading.
+	ErrTaskNotPausable = errors.New("task can only be paused while downloading")
+	// ErrTaskNotPaused is returned when trying to resume a task which isn't paused.
+	ErrTaskNotPaused = errors.New("task isn't paused")
+	// ErrTaskNotAttached is returned when trying to resume a restored task before its download is attached again.
+	ErrTaskNotAttached = errors.New("task isn't attached to its download yet")
+	// ErrTaskStateChanged is returned when the download of a task was replaced while it was paused or resumed.
+	ErrTaskStateChanged = errors.New("task changed while it was being controlled")
 )
 
 type Task interface {
 	GetId() uuid.UUID
 	GetStatus() *TaskStatus
+	// GetSummary returns the redacted summary of the task which can be shown to any client.
+	GetSummary() TaskSummary
 	Perform() error
+
+	// Cancel stops the task and discards all of its progress.
+	Cancel() error
+	// Pause halts the task until Resume is called.
+	Pause() error
+	Resume() error
 }
 
 type TaskStatus struct {
-	Id      string      `json:"id"`
-	Running bool        `json:"running"`
-	State   string      `json:"state"`
-	Result  interface{} `json:"result,omitempty"`
-	Err     interface{} `json:"error,omitempty"`
+	Id        string      `json:"id"`
+	CreatedAt time.Time   `json:"created"`
+	Running   bool        `json:"running"`
+	State     string      `json:"state"`
+	Progress  *Progress   `json:"progress,omitempty"`
+	URIs      []aria2.URI `json:"uris,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	Err       *TaskError  `json:"error,omitempty"`
+	// Attempts contains the failed attempts of the stages
+	Attempts []TaskAttempt `json:"attempts,omitempty"`
+	// FinishedAt is the time the task stopped for good
+	FinishedAt *time.Time `json:"finished,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 func NewTaskStatus(id string) *TaskStatus {
-	return &TaskStatus{Id: id, State: "waiting"}
+	return &TaskStatus{Id: id, CreatedAt: time.Now().UTC(), State: "waiting"}
+}
+
+// Finished reports whether the task has stopped for good.
+func (status *TaskStatus) Finished() bool {
+	return !status.Running && status.State != "waiting"
 }
 
 func (status *TaskStatus) Start() {
@@ -40,23 +79,122 @@ func (status *TaskStatus) EnterState(state string) {
 	status.State = state
 }
 
-func (status *TaskStatus) Error(err error) {
+// finish marks the task as stopped for good.
+func (status *TaskStatus) finish(state string) {
+	now := time.Now().UTC()
 	status.Running = false
-	status.Err = err.Error()
+	status.State = state
+	status.FinishedAt = &now
+}
+
+// finishedBefore reports whether the task finished before the given time.
+// Records from before the finish time was stored use the time they were created at.
+func (status *TaskStatus) finishedBefore(t time.Time) bool {
+	if !status.Finished() {
+		return false
+	}
+
+	if status.FinishedAt != nil {
+		return status.FinishedAt.Before(t)
+	}
+
+	return status.CreatedAt.Before(t)
+}
+
+func (status *TaskStatus) Error(stage string, err error) {
+	status.finish("error")
+	status.Err = NewTaskError(stage, err)
+}
+
+func (status *TaskStatus) Cancel() {
+	status.finish("cancelled")
 }
 
 func (status *TaskStatus) Done(res interface{}) {
-	status.Running = false
-	status.State = "done"
+	status.finish("done")
 	status.Result = res
 }
 
-func formatFilename(template string, filename string) string {
-	ext := filepath.Ext(filename)
+// TaskError describes why a task failed.
+type TaskError struct {
+	// Code is the aria2 exit status, it's 0 for errors which weren't reported by aria2
+	Code     aria2.ExitStatus `json:"code,omitempty"`
+	Category string           `json:"category"`
+	Message  string           `json:"message"`
+	// Description is the description of the aria2 exit status
+	Description string `json:"description,omitempty"`
+	// URI is the URI which failed to download
+	URI       string `json:"uri,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// NewTaskError creates the TaskError of a stage which failed with the given error.
+func NewTaskError(stage string, err error) *TaskError {
+	taskErr := &TaskError{Message: err.Error(), Retryable: isRetryable(err)}
+
+	switch err := err.(type) {
+	case *aria2.DownloadError:
+		taskErr.Code = err.ExitStatus
+		taskErr.Category = string(err.ExitStatus.Category())
+		taskErr.Description = err.Description
+		taskErr.URI = err.URI
+		if err.Message != "" {
+			taskErr.Message = err.Message
+		}
+	case permanentError:
+		taskErr.Category = "task"
+	default:
+		if stage == "upload" {
+			taskErr.Category = "storage"
+		} else {
+			taskErr.Category = "internal"
+		}
+	}
+
+	return taskErr
+}
+
+// UnmarshalJSON also accepts the plain error messages of older task records.
+func (e *TaskError) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		*e = TaskError{Category: "unknown", Message: message}
+		return nil
+	}
+
+	type taskError TaskError
+	return json.Unmarshal(data, (*taskError)(e))
+}
+
+// formatFilename fills in the template using the path of a file relative to the download directory.
+func formatFilename(template string, relPath string) string {
+	filename := path.Base(relPath)
+	ext := path.Ext(filename)
 	name := strings.TrimSuffix(filename, ext)
 
-	r := strings.NewReplacer("{filename}", filename, "{name}", name, "{ext}", ext)
-	return r.Replace(template)
+	dir := path.Dir(relPath)
+	if dir == "." {
+		dir = ""
+	}
+
+	r := strings.NewReplacer("{path}", relPath, "{dir}", dir, "{filename}", filename, "{name}", name, "{ext}", ext)
+	return strings.TrimPrefix(path.Clean(r.Replace(template)), "/")
+}
+
+// downloadedFile is a file which was downloaded by aria2.
+type downloadedFile struct {
+	aria2.File
+	// RelPath is the slash separated path of the file relative to the download directory
+	RelPath string
+}
+
+func newDownloadedFile(file aria2.File, dir string) downloadedFile {
+	relPath, err := filepath.Rel(dir, file.Path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		relPath = filepath.Base(file.Path)
+	}
+
+	return downloadedFile{file, filepath.ToSlash(relPath)}
 }
 
 type DownloadTask interface {
@@ -69,27 +207,88 @@ type downloadTask struct {
 	id uuid.UUID
 
 	ctx    context.Context
+	cancel context.CancelFunc
 	server *Server
 
+	// mu guards the state transitions caused by Cancel, Pause and Resume
+	mu sync.Mutex
+
 	req DownloadRequest
+	// requestFile is the path of the torrent or metalink of a restored request,
+	// it's only read when the download is added.
+	requestFile string
 
 	status *TaskStatus
-	gid    *aria2.GID
-	file   *aria2.File
-	result UploadOutput
+	// backend is the aria2 backend the downloads were added to
+	backend *Backend
+	// cancelAttempt aborts the current download attempt
+	cancelAttempt context.CancelFunc
+	// gids are the downloads added for the request
+	gids []aria2.GID
+	// followers are the downloads which were started by the downloads of gids,
+	// the actual contents of a torrent for example.
+	followers []aria2.GID
+	// active is the download which is currently being waited for
+	active  *aria2.GID
+	files   []downloadedFile
+	results []UploadOutput
 }
 
 func NewDownloadTask(server *Server, req DownloadRequest) DownloadTask {
 	id := uuid.New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	status := NewTaskStatus(id.String())
+	status.Metadata = req.Metadata
 
 	return &downloadTask{
-		id:  id,
-		ctx: context.Background(),
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
 
 		server: server,
 		req:    req,
-		status: NewTaskStatus(id.String()),
+		status: status,
+	}
+}
+
+// RestoreDownloadTask recreates a download task from its persisted record.
+// If the record contains GIDs, performing the task re-attaches to the existing aria2 downloads.
+func RestoreDownloadTask(server *Server, record TaskRecord) DownloadTask {
+	status := record.Status
+	ctx, cancel := context.WithCancel(context.Background())
+
+	task := &downloadTask{
+		id:     record.Id,
+		ctx:    ctx,
+		cancel: cancel,
+
+		server:      server,
+		req:         record.Request,
+		requestFile: record.RequestFile,
+		status:      &status,
+	}
+
+	// Records from before there were several backends belong to the only backend there was
+	backendName := record.Backend
+	if backendName == "" {
+		backendName = defaultBackendName
+		if server.Config.ManageAria2 {
+			backendName = managedBackendName
+		}
 	}
+
+	// Without its backend the download has to be started again
+	if backend, ok := server.Backends.Get(backendName); ok {
+		task.backend = backend
+		for _, gid := range record.GIDs {
+			task.gids = append(task.gids, backend.Client.GetGID(gid))
+		}
+	} else if len(record.GIDs) > 0 {
+		log.Printf("[%s] aria2 backend %s no longer exists, restarting download\n", task.id, backendName)
+	}
+
+	return task
 }
 
 func (task *downloadTask) GetId() uuid.UUID {
@@ -103,91 +302,624 @@ func (task *downloadTask) String() string {
 func (task *downloadTask) Perform() (err error) {
 	defer func() { _ = task.Cleanup() }()
 	defer task.SendCallback()
-
+	defer task.commit()
+	defer task.cancel()
+
+	task.mu.Lock()
+	// The task was cancelled before it started
+	if task.ctx.Err() != nil {
+		task.mu.Unlock()
+		return task.ctx.Err()
+	}
 	task.status.Start()
+	task.mu.Unlock()
 
 	log.Printf("[%s] download started\n", task.id)
-	task.status.EnterState("downloading")
-	err = task.Download()
+	task.setState("downloading")
+	err = task.withRetries("download", task.server.Config.DownloadRetry, task.Download, task.resetDownload)
 	if err != nil {
-		log.Printf("[%s] download failed: %s\n", task.id, err)
-		task.status.Error(err)
+		task.stop("download", err)
 		return
 	}
 
 	log.Printf("[%s] upload started\n", task.id)
-	task.status.State = "uploading"
-	err = task.Upload()
+	task.setState("uploading")
+	err = task.withRetries("upload", task.server.Config.UploadRetry, task.Upload, nil)
 	if err != nil {
-		log.Printf("[%s] upload failed: %s\n", task.id, err)
-		task.status.Error(err)
+		task.stop("upload", err)
 		return
 	}
 
 	log.Printf("[%s] done\n", task.id)
-	task.status.Done(task.result)
+	task.mu.Lock()
+	task.status.Done(task.results)
+	task.mu.Unlock()
 	return
 }
 
+// withRetries performs a stage until it succeeds or the retry policy is exhausted.
+// reset is called before retrying the stage.
+func (task *downloadTask) withRetries(stage string, policy RetryPolicy, perform func() error, reset func()) error {
+	for attempt := 1; ; attempt++ {
+		err := perform()
+		if err == nil || task.ctx.Err() != nil {
+			return err
+		}
+
+		if err == errBackendFailover {
+			log.Printf("[%s] %s attempt %d moved to another aria2 backend\n", task.id, stage, attempt)
+			attempt--
+			if reset != nil {
+				reset()
+			}
+			continue
+		}
+
+		failure := TaskAttempt{Stage: stage, Attempt: attempt, Err: err.Error(), Time: time.Now().UTC()}
+		retry := attempt < policy.MaxAttempts && isRetryable(err)
+
+		var delay time.Duration
+		if retry {
+			delay = policy.Backoff(attempt)
+			retryAt := failure.Time.Add(delay)
+			failure.RetryAt = &retryAt
+
+			log.Printf("[%s] %s attempt %d failed, retrying in %s: %s\n", task.id, stage, attempt, delay, err)
+		}
+
+		task.mu.Lock()
+		task.status.Attempts = append(task.status.Attempts, failure)
+		task.mu.Unlock()
+		task.commit()
+
+		if !retry {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-task.ctx.Done():
+			return task.ctx.Err()
+		}
+
+		if reset != nil {
+			reset()
+		}
+	}
+}
+
+// resetDownload removes the failed downloads so that they are added again.
+func (task *downloadTask) resetDownload() {
+	_ = task.Cleanup()
+
+	task.mu.Lock()
+	task.backend = nil
+	task.gids = nil
+	task.followers = nil
+	task.active = nil
+	// The new download isn't paused
+	if task.status.State == "paused" {
+		task.status.EnterState("downloading")
+	}
+	task.mu.Unlock()
+
+	task.commit()
+}
+
+// getBackend returns the aria2 backend of the task, it's nil if no download has been added.
+func (task *downloadTask) getBackend() *Backend {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	return task.backend
+}
+
+// failover aborts the current download attempt if it uses the given backend.
+// The download is then started again on another backend.
+func (task *downloadTask) failover(backend *Backend) {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	if task.backend != backend || task.cancelAttempt == nil {
+		return
+	}
+
+	log.Printf("[%s] aria2 backend %s is unavailable, moving download\n", task.id, backend.Name)
+	task.cancelAttempt()
+}
+
+// setState changes the state of the task and commits it.
+func (task *downloadTask) setState(state string) {
+	task.mu.Lock()
+	task.status.EnterState(state)
+	task.mu.Unlock()
+
+	task.commit()
+}
+
+func (task *downloadTask) setProgress(p *Progress) {
+	task.mu.Lock()
+	task.status.Progress = p
+	task.mu.Unlock()
+}
+
+func (task *downloadTask) setURIs(uris []aria2.URI) {
+	task.mu.Lock()
+	task.status.URIs = uris
+	task.mu.Unlock()
+}
+
+// stop marks the task as cancelled if it was cancelled and as failed otherwise.
+func (task *downloadTask) stop(stage string, err error) {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	if task.ctx.Err() == context.Canceled {
+		log.Printf("[%s] %s cancelled\n", task.id, stage)
+		task.status.Cancel()
+		return
+	}
+
+	log.Printf("[%s] %s failed: %s\n", task.id, stage, err)
+	task.status.Error(stage, err)
+}
+
+func (task *downloadTask) Cancel() error {
+	task.mu.Lock()
+
+	switch {
+	case task.status.Running:
+		// Cancelling the context removes the aria2 download or aborts the upload
+		task.cancel()
+		task.mu.Unlock()
+		return nil
+	case task.status.State == "waiting":
+		// Perform doesn't start a cancelled task, so the state has to be changed here
+		task.cancel()
+		task.status.Cancel()
+		task.mu.Unlock()
+
+		task.commit()
+		return nil
+	}
+
+	task.mu.Unlock()
+	return ErrTaskNotRunning
+}
+
+func (task *downloadTask) Pause() error {
+	task.mu.Lock()
+	active := task.active
+	if task.status.State != "downloading" || active == nil {
+		task.mu.Unlock()
+		return ErrTaskNotPausable
+	}
+	task.mu.Unlock()
+
+	return task.control(active, "downloading", "paused", (*aria2.GID).Pause)
+}
+
+func (task *downloadTask) Resume() error {
+	task.mu.Lock()
+	active := task.active
+	if task.status.State != "paused" {
+		task.mu.Unlock()
+		return ErrTaskNotPaused
+	}
+	task.mu.Unlock()
+
+	// Tasks restored as paused don't have a download until it's attached again
+	if active == nil {
+		return ErrTaskNotAttached
+	}
+
+	return task.control(active, "paused", "downloading", (*aria2.GID).Unpause)
+}
+
+// control calls the method on the download and changes the state of the task from "from" to "to".
+// The lock isn't held during the call, so the state only changes if the download is still the active one.
+func (task *downloadTask) control(active *aria2.GID, from, to string, call func(*aria2.GID) error) error {
+	if err := call(active); err != nil {
+		return err
+	}
+
+	task.mu.Lock()
+	if task.active != active || task.status.State != from {
+		task.mu.Unlock()
+		return ErrTaskStateChanged
+	}
+	task.status.EnterState(to)
+	task.mu.Unlock()
+
+	task.commit()
+	return nil
+}
+
+// Record returns the persistable state of the task.
+func (task *downloadTask) Record() TaskRecord {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	record := TaskRecord{
+		Id:          task.id,
+		Request:     task.req,
+		RequestFile: task.requestFile,
+		Status:      *task.status,
+	}
+
+	if task.backend != nil {
+		record.Backend = task.backend.Name
+	}
+
+	for _, gid := range task.gids {
+		record.GIDs = append(record.GIDs, gid.GID)
+	}
+
+	return record
+}
+
+func (task *downloadTask) save() {
+	if err := task.server.Store.Put(task.Record()); err != nil {
+		log.Printf("[%s] couldn't save task: %s\n", task.id, err)
+	}
+}
+
+// commit saves the task and notifies the subscribers about its new state.
+func (task *downloadTask) commit() {
+	task.save()
+	task.server.Events.Publish(StateEvent, task.GetSummary())
+}
+
 func (task *downloadTask) SendCallback() {
 	if task.req.CallbackUrl != "" {
-		task.server.GoSendCallback(task.req.CallbackUrl, task.status)
+		task.server.GoSendCallback(task.req.CallbackUrl, task.GetSummary().Status)
 	}
 }
 
+// GetStatus returns a snapshot of the current status of the task.
 func (task *downloadTask) GetStatus() *TaskStatus {
-	return task.status
+	task.mu.Lock()
+	status := *task.status
+	task.mu.Unlock()
+
+	return &status
+}
+
+func (task *downloadTask) GetSummary() TaskSummary {
+	return NewTaskSummary(task.Record())
 }
 
 func (task *downloadTask) Download() error {
-	ariaClient := &task.server.AriaClient
-	status, err := ariaClient.DownloadWithContext(task.ctx, aria2.URIs(task.req.Url), &aria2.Options{})
-	gid := ariaClient.GetGID(status.GID)
-	task.gid = &gid
+	ctx, cancel := context.WithCancel(task.ctx)
+	defer cancel()
+
+	task.mu.Lock()
+	task.cancelAttempt = cancel
+	task.mu.Unlock()
+
+	defer func() {
+		task.mu.Lock()
+		task.cancelAttempt = nil
+		task.mu.Unlock()
+	}()
+
+	err := task.download(ctx)
+	// The attempt was aborted by failover rather than by cancelling the task
+	if err != nil && ctx.Err() != nil && task.ctx.Err() == nil {
+		return errBackendFailover
+	}
+
+	return err
+}
+
+// download performs a single attempt of the download stage.
+func (task *downloadTask) download(ctx context.Context) error {
+	if task.gids == nil {
+		backend, err := task.server.Backends.Pick()
+		if err != nil {
+			return err
+		}
+
+		gids, err := task.addDownload(backend.Client)
+		if err != nil {
+			return err
+		}
+
+		task.mu.Lock()
+		task.backend = backend
+		task.gids = gids
+		task.mu.Unlock()
+		task.save()
+	}
+
+	var files []downloadedFile
+	for _, gid := range task.gids {
+		if task.req.hasFileGlobs() {
+			if err := task.selectFiles(gid); err != nil {
+				return err
+			}
+		}
+
+		gidFiles, err := task.downloadFiles(ctx, gid)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, gidFiles...)
+	}
+
+	if len(files) == 0 {
+		return permanentError{errors.New("no files were selected")}
+	}
+
+	task.files = files
+	return nil
+}
 
+// addDownload adds the downloads for the request to aria2.
+func (task *downloadTask) addDownload(client *aria2.Client) ([]aria2.GID, error) {
+	req := task.req
+	if task.requestFile != "" {
+		if err := readRequestFile(&req, task.requestFile); err != nil {
+			return nil, permanentError{err}
+		}
+	}
+
+	options := req.AriaOptions()
+
+	switch {
+	case req.Torrent != nil:
+		gid, err := client.AddTorrent(req.Torrent, req.URIs(), options)
+		return []aria2.GID{gid}, err
+	case req.Metalink != nil:
+		return client.AddMetalink(req.Metalink, options)
+	default:
+		gid, err := client.AddUri(req.URIs(), options)
+		return []aria2.GID{gid}, err
+	}
+}
+
+// downloadFiles waits for the download and all downloads following it.
+// It returns the selected files.
+func (task *downloadTask) downloadFiles(ctx context.Context, gid aria2.GID) (files []downloadedFile, err error) {
+	status, err := task.attach(ctx, gid)
+	if err != nil {
+		return
+	}
+
+	if len(status.FollowedBy) == 0 {
+		for _, file := range status.Files {
+			f := newDownloadedFile(file, status.Dir)
+			if file.Selected && task.req.SelectsFile(f.Index, f.RelPath) {
+				files = append(files, f)
+			}
+		}
+
+		return
+	}
+
+	// The download only contained metadata, like a torrent file, the actual files are in the followers
+	for _, followerGID := range status.FollowedBy {
+		follower := task.getBackend().Client.GetGID(followerGID)
+
+		task.mu.Lock()
+		task.followers = append(task.followers, follower)
+		task.mu.Unlock()
+
+		if task.req.hasFileGlobs() {
+			if err = task.selectFiles(follower); err != nil {
+				return
+			}
+		}
+
+		var followerFiles []downloadedFile
+		followerFiles, err = task.downloadFiles(ctx, follower)
+		if err != nil {
+			return
+		}
+
+		files = append(files, followerFiles...)
+	}
+
+	return
+}
+
+// selectFiles restricts a download to the selected files and resumes it.
+// Downloads which need to be selected are added paused
+// or paused by aria2 after their metadata was downloaded.
+func (task *downloadTask) selectFiles(gid aria2.GID) error {
+	status, err := gid.TellStatus("status", "dir", "files")
 	if err != nil {
 		return err
 	}
 
-	files := status.Files
-	if len(files) != 1 {
-		return fmt.Errorf("invalid number of files downloaded: %d", len(files))
+	if status.Status != aria2.StatusPaused {
+		return nil
 	}
 
-	task.file = &files[0]
-	return nil
+	var indices []string
+	for _, file := range status.Files {
+		f := newDownloadedFile(file, status.Dir)
+		if task.req.SelectsFile(f.Index, f.RelPath) {
+			indices = append(indices, strconv.Itoa(f.Index))
+		}
+	}
+
+	if len(indices) == 0 {
+		return permanentError{errors.New("none of the files match the selection")}
+	}
+
+	if err := gid.ChangeOption(&aria2.Options{SelectFile: strings.Join(indices, ",")}); err != nil {
+		return err
+	}
+
+	return gid.Unpause()
+}
+
+// attach waits for the aria2 download to finish.
+// This also works for downloads which were added before the task was restored,
+// downloads which completed in the meantime are returned immediately.
+func (task *downloadTask) attach(ctx context.Context, gid aria2.GID) (status aria2.Status, err error) {
+	status, err = gid.TellStatus()
+	if err != nil {
+		return
+	}
+
+	task.mu.Lock()
+	task.active = &gid
+	task.mu.Unlock()
+
+	switch status.Status {
+	case aria2.StatusCompleted:
+	case aria2.StatusPaused:
+		task.setState("paused")
+		fallthrough
+	case aria2.StatusActive, aria2.StatusWaiting:
+		status, err = gid.WaitForDownloadWithContext(ctx)
+		if err != nil {
+			return
+		}
+	case aria2.StatusError:
+		err = aria2.NewDownloadError(status)
+		return
+	default:
+		err = fmt.Errorf("download %s is %s", gid.GID, status.Status)
+		return
+	}
+
+	task.setProgress(NewDownloadProgress(status))
+	return
+}
+
+// queueProgress adds the calls required to refresh the progress of the active download to the batch.
+// The returned function applies the results once the batch has been sent,
+// it's nil if the task isn't downloading.
+func (task *downloadTask) queueProgress(batch *aria2.Batch) func() {
+	task.mu.Lock()
+	active, state := task.active, task.status.State
+	task.mu.Unlock()
+
+	if active == nil || state != "downloading" {
+		return nil
+	}
+
+	var status aria2.Status
+	var uris []aria2.URI
+	statusCall := batch.TellStatus(&status, active.GID, progressStatusKeys...)
+	urisCall := batch.GetURIs(&uris, active.GID)
+
+	return func() {
+		if statusCall.Err == nil {
+			task.setProgress(NewDownloadProgress(status))
+		}
+
+		if urisCall.Err == nil {
+			task.setURIs(uris)
+		}
+	}
+}
+
+// uploadNames determines the names of the files in the storage.
+func (task *downloadTask) uploadNames() ([]string, error) {
+	names := make([]string, len(task.files))
+	seen := make(map[string]bool, len(task.files))
+
+	for i, file := range task.files {
+		name := file.RelPath
+		if task.req.Name != "" {
+			name = formatFilename(task.req.Name, file.RelPath)
+		}
+
+		if seen[name] {
+			return nil, permanentError{fmt.Errorf("multiple files would be uploaded as %s, use {path} in the name", name)}
+		}
+
+		seen[name] = true
+		names[i] = name
+	}
+
+	return names, nil
 }
 
 func (task *downloadTask) Upload() error {
-	file := task.file
-	if file == nil {
+	if len(task.files) == 0 {
 		return errors.New("no file to upload")
 	}
 
-	name := task.req.Name
-	if name != "" {
-		name = formatFilename(name, file.Path)
-	} else {
-		name = path.Base(file.Path)
+	names, err := task.uploadNames()
+	if err != nil {
+		return err
 	}
 
+	// Files which were uploaded by a previous attempt are skipped
+	var total, completed uint
+	for i, file := range task.files {
+		total += file.Length
+		if i < len(task.results) {
+			completed += file.Length
+		}
+	}
+
+	progress := newUploadProgress(total, completed, task.setProgress)
+	task.setProgress(progress.Progress())
+
+	for i := len(task.results); i < len(task.files); i++ {
+		result, err := task.uploadFile(task.files[i], names[i], progress)
+		if err != nil {
+			return err
+		}
+
+		task.results = append(task.results, result)
+	}
+
+	return nil
+}
+
+func (task *downloadTask) uploadFile(file downloadedFile, name string, progress *uploadProgress) (UploadOutput, error) {
 	f, err := os.Open(file.Path)
 	if err != nil {
-		return err
+		return UploadOutput{}, err
 	}
+	defer func() { _ = f.Close() }()
 
-	storage := task.server.Storage
-	result, err := storage.Upload(task.ctx, f, UploadOptions{Bucket: task.req.Bucket, Filename: name})
-	_ = f.Close()
+	// Records from before there were several storages have no storage and belong to the default one
+	target, ok := task.server.Config.storageTarget(task.req.Storage)
+	if !ok {
+		return UploadOutput{}, permanentError{fmt.Errorf("unknown storage: %s", task.req.Storage)}
+	}
 
-	task.result = result
+	// The buckets of the target may have changed since the task was created
+	if !target.AllowsBucket(task.req.Bucket) {
+		return UploadOutput{}, permanentError{fmt.Errorf("bucket %s not allowed for storage %s", task.req.Bucket, target.Name)}
+	}
 
-	return err
+	storage := task.server.Storages[target.Name]
+	return storage.Upload(task.ctx, progress.Reader(f), UploadOptions{Bucket: task.req.Bucket, Filename: name})
 }
 
 func (task *downloadTask) Cleanup() (err error) {
-	if task.gid != nil {
-		err = task.gid.Delete()
+	task.mu.Lock()
+	backend, followers := task.backend, task.followers
+	task.mu.Unlock()
+
+	// The downloads of an unreachable backend are removed by the monitor once it's reachable again
+	remove := func(gid aria2.GID) error {
+		err := gid.Delete()
+		if err != nil && backend != nil && (!backend.Healthy() || isRetryable(err)) {
+			backend.orphan(gid.GID)
+		}
+
+		return err
+	}
+
+	for _, follower := range followers {
+		_ = remove(follower)
+	}
+
+	for _, gid := range task.gids {
+		if deleteErr := remove(gid); err == nil {
+			err = deleteErr
+		}
 	}
 
 	return
@@ -0,0 +1,214 @@
+package arias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/MyAnimeStream/arias/aria2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFormatFilename(t *testing.T) {
+	assert.Equal(t, "Show/ep1.mkv", formatFilename("{path}", "Show/ep1.mkv"))
+	assert.Equal(t, "ep1.mp4", formatFilename("{name}.mp4", "Show/ep1.mkv"))
+	assert.Equal(t, "anime/Show/ep1.mkv", formatFilename("anime/{dir}/{filename}", "Show/ep1.mkv"))
+	assert.Equal(t, "ep1.mkv", formatFilename("{dir}/{name}{ext}", "ep1.mkv"))
+}
+
+func TestSelectsFile(t *testing.T) {
+	req := DownloadRequest{Files: []string{"1", "3-4", "*.mkv", "Extras/*"}}
+
+	assert.True(t, req.SelectsFile(1, "a.txt"))
+	assert.False(t, req.SelectsFile(2, "b.txt"))
+	assert.True(t, req.SelectsFile(4, "d.txt"))
+	assert.True(t, req.SelectsFile(5, "Show/ep1.mkv"))
+	assert.True(t, req.SelectsFile(6, "Extras/op.mp4"))
+	assert.False(t, req.SelectsFile(7, "Show/Extras/op.mp4"))
+
+	all := DownloadRequest{}
+	assert.True(t, all.SelectsFile(1, "a.txt"))
+}
+
+func TestNewTaskError(t *testing.T) {
+	downloadErr := &aria2.DownloadError{GID: "2089b05ecca3d829", ExitStatus: aria2.NetworkError, Message: "connection reset"}
+	taskErr := NewTaskError("download", downloadErr)
+	assert.Equal(t, aria2.NetworkError, taskErr.Code)
+	assert.Equal(t, "network", taskErr.Category)
+	assert.Equal(t, "connection reset", taskErr.Message)
+	assert.True(t, taskErr.Retryable)
+
+	taskErr = NewTaskError("download", permanentError{errors.New("no files were selected")})
+	assert.Equal(t, "task", taskErr.Category)
+	assert.False(t, taskErr.Retryable)
+}
+
+func TestTaskErrorUnmarshalLegacy(t *testing.T) {
+	var status TaskStatus
+	err := json.Unmarshal([]byte(`{"state": "error", "error": "download failed"}`), &status)
+	assert.NoError(t, err)
+	assert.Equal(t, &TaskError{Category: "unknown", Message: "download failed"}, status.Err)
+}
+
+// waitForState waits until the task has entered the given state.
+func waitForState(t *testing.T, task Task, state string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for task.GetStatus().State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("task is %s instead of %s", task.GetStatus().State, state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDownloadTaskControl(t *testing.T) {
+	fake, client := newFakeAria2(t)
+	s := newTestServer(t, NewBackend(defaultBackendName, client))
+
+	task := NewDownloadTask(s, DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"})
+	assert.Equal(t, ErrTaskNotPaused, task.Resume())
+
+	performed := make(chan error, 1)
+	go func() { performed <- task.Perform() }()
+	waitForState(t, task, "downloading")
+
+	// The task can only be paused once it's waiting for the download
+	dt := task.(*downloadTask)
+	for {
+		dt.mu.Lock()
+		active := dt.active
+		dt.mu.Unlock()
+
+		if active != nil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.NoError(t, task.Pause())
+	assert.Equal(t, "paused", task.GetStatus().State)
+	assert.Equal(t, ErrTaskNotPausable, task.Pause())
+
+	assert.NoError(t, task.Resume())
+	assert.Equal(t, "downloading", task.GetStatus().State)
+
+	assert.NoError(t, task.Cancel())
+	<-performed
+	assert.Equal(t, "cancelled", task.GetStatus().State)
+	assert.Equal(t, ErrTaskNotRunning, task.Cancel())
+
+	called := fake.called()
+	assert.Contains(t, called, "aria2.pause")
+	assert.Contains(t, called, "aria2.unpause")
+	assert.Contains(t, called, "aria2.remove")
+}
+
+func TestResumeRestoredTask(t *testing.T) {
+	_, client := newFakeAria2(t)
+	s := newTestServer(t, NewBackend(defaultBackendName, client))
+
+	// The download isn't attached until the task is performed
+	task := RestoreDownloadTask(s, TaskRecord{
+		Id:      uuid.New(),
+		Request: DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"},
+		GIDs:    []string{"2089b05ecca3d829"},
+		Status:  TaskStatus{Running: true, State: "paused"},
+	})
+
+	assert.Equal(t, ErrTaskNotAttached, task.Resume())
+	assert.Equal(t, "paused", task.GetStatus().State)
+}
+
+func TestCancelWaitingTask(t *testing.T) {
+	fake, client := newFakeAria2(t)
+	s := newTestServer(t, NewBackend(defaultBackendName, client))
+
+	task := NewDownloadTask(s, DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"})
+	assert.NoError(t, task.Cancel())
+	assert.Equal(t, "cancelled", task.GetStatus().State)
+
+	assert.Equal(t, context.Canceled, task.Perform())
+	assert.Equal(t, "cancelled", task.GetStatus().State)
+	assert.NotContains(t, fake.called(), "aria2.addUri")
+}
+
+func TestDownloadTaskFailover(t *testing.T) {
+	fakeA, clientA := newFakeAria2(t)
+	fakeB, clientB := newFakeAria2(t)
+	a, b := NewBackend("a", clientA), NewBackend("b", clientB)
+
+	s := newTestServer(t, a, b)
+	s.Backends.onDown = s.failover
+
+	task := NewDownloadTask(s, DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"})
+	dt := task.(*downloadTask)
+	s.PerformTask(task)
+
+	backendOf := func() *Backend {
+		dt.mu.Lock()
+		defer dt.mu.Unlock()
+
+		if dt.active == nil {
+			return nil
+		}
+		return dt.backend
+	}
+
+	require.Eventually(t, func() bool { return backendOf() == a }, 5*time.Second, 5*time.Millisecond)
+	// The download is waited for once its status was checked again after attaching it
+	require.Eventually(t, func() bool { return count(fakeA.called(), "aria2.tellStatus") >= 2 }, 5*time.Second, 5*time.Millisecond)
+
+	fakeA.setDown(true)
+	for i := 0; i < maxBackendFailures; i++ {
+		s.Backends.check(a)
+	}
+	assert.False(t, a.Healthy())
+
+	require.Eventually(t, func() bool { return backendOf() == b }, 5*time.Second, 5*time.Millisecond)
+	assert.Empty(t, task.GetStatus().Attempts, "failover mustn't use up an attempt")
+	assert.Equal(t, "downloading", task.GetStatus().State)
+	assert.Contains(t, fakeB.called(), "aria2.addUri")
+	assert.NotContains(t, fakeA.called(), "aria2.remove")
+
+	// The download left on a is removed once it's reachable again
+	fakeA.setDown(false)
+	s.Backends.check(a)
+	assert.True(t, a.Healthy())
+	assert.Contains(t, fakeA.called(), "aria2.remove")
+
+	assert.NoError(t, task.Cancel())
+}
+
+// count returns how often value occurs in values.
+func count(values []string, value string) int {
+	n := 0
+	for _, v := range values {
+		if v == value {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestUploadFileChecksBucket(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "ep1.mkv")
+	require.NoError(t, ioutil.WriteFile(path, []byte("episode"), 0644))
+	file := downloadedFile{File: aria2.File{Path: path}, RelPath: "ep1.mkv"}
+
+	task := NewDownloadTask(s, DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "anime"}).(*downloadTask)
+	_, err := task.uploadFile(file, "ep1.mkv", newUploadProgress(7, 0, nil))
+	assert.NoError(t, err)
+
+	// The bucket was allowed when the task was created, but the config changed since
+	task = NewDownloadTask(s, DownloadRequest{Url: "https://example.org/ep1.mkv", Bucket: "music"}).(*downloadTask)
+	_, err = task.uploadFile(file, "ep1.mkv", newUploadProgress(7, 0, nil))
+	assert.True(t, errors.As(err, &permanentError{}), "expected a permanent error, got %v", err)
+	assert.NoFileExists(t, filepath.Join(s.Config.Local.Root, "music", "ep1.mkv"))
+}
@@ -0,0 +1,275 @@
+package arias
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrTaskNotFound is returned by a TaskStore if there is no record for the requested task.
+var ErrTaskNotFound = errors.New("task not found")
+
+// TaskRecord is the persisted state of a download task.
+// It contains everything required to resume the task after a restart.
+type TaskRecord struct {
+	Id      uuid.UUID       `json:"id"`
+	Request DownloadRequest `json:"request"`
+	// GIDs of the aria2 downloads, empty if the download hasn't been added yet.
+	// There is usually only one download, except for Metalinks.
+	GIDs []string `json:"gids,omitempty"`
+	// Backend is the name of the aria2 backend the GIDs belong to
+	Backend string `json:"backend,omitempty"`
+	// RequestFile is the path of the torrent or metalink of the request
+	// if the store keeps it outside of the record.
+	RequestFile string     `json:"requestFile,omitempty"`
+	Status      TaskStatus `json:"status"`
+}
+
+func (r *TaskRecord) UnmarshalJSON(data []byte) error {
+	type record TaskRecord
+	var legacy struct {
+		record
+		// GID is the download of records from before there could be several
+		GID string `json:"gid"`
+	}
+
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	*r = TaskRecord(legacy.record)
+	if len(r.GIDs) == 0 && legacy.GID != "" {
+		r.GIDs = []string{legacy.GID}
+	}
+
+	return nil
+}
+
+// requestFileExts are the extensions of the request files by their content.
+var requestFileExts = []string{".torrent", ".metalink"}
+
+// readRequestFile reads the torrent or metalink at the given path into the request.
+func readRequestFile(req *DownloadRequest, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Ext(path) == ".metalink" {
+		req.Metalink = data
+	} else {
+		req.Torrent = data
+	}
+
+	return nil
+}
+
+// TaskStore persists task records.
+type TaskStore interface {
+	Put(record TaskRecord) error
+	Get(id uuid.UUID) (TaskRecord, error)
+	Delete(id uuid.UUID) error
+	All() ([]TaskRecord, error)
+	Close() error
+}
+
+// NewTaskStoreFromPath creates a TaskStore backed by the database at the given path.
+// If path is empty, the tasks are only kept in memory.
+func NewTaskStoreFromPath(path string) (TaskStore, error) {
+	if path == "" {
+		return NewMemoryTaskStore(), nil
+	}
+
+	return NewBoltTaskStore(path)
+}
+
+type memoryTaskStore struct {
+	mu      sync.RWMutex
+	records map[uuid.UUID]TaskRecord
+}
+
+// NewMemoryTaskStore creates a TaskStore which doesn't persist anything.
+func NewMemoryTaskStore() TaskStore {
+	return &memoryTaskStore{records: make(map[uuid.UUID]TaskRecord)}
+}
+
+func (s *memoryTaskStore) Put(record TaskRecord) error {
+	s.mu.Lock()
+	s.records[record.Id] = record
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryTaskStore) Get(id uuid.UUID) (TaskRecord, error) {
+	s.mu.RLock()
+	record, ok := s.records[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return record, ErrTaskNotFound
+	}
+
+	return record, nil
+}
+
+func (s *memoryTaskStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	delete(s.records, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryTaskStore) All() ([]TaskRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]TaskRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *memoryTaskStore) Close() error {
+	return nil
+}
+
+var taskBucket = []byte("tasks")
+
+type boltTaskStore struct {
+	db *bolt.DB
+	// fileDir contains the torrents and metalinks of the requests
+	fileDir string
+}
+
+// NewBoltTaskStore creates a TaskStore which persists the records in a bolt database at the given path.
+// The file is created if it doesn't exist.
+// Torrents and metalinks are stored in the directory {path}.files instead of the records.
+func NewBoltTaskStore(path string) (s TaskStore, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(taskBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return
+	}
+
+	s = &boltTaskStore{db: db, fileDir: path + ".files"}
+	return
+}
+
+// putRequestFile moves the torrent or metalink of the request out of the record into its own file.
+// The file is only written once, the request can't change.
+func (s *boltTaskStore) putRequestFile(record *TaskRecord) error {
+	req := &record.Request
+
+	var content []byte
+	var ext string
+	switch {
+	case req.Torrent != nil:
+		content, ext = req.Torrent, ".torrent"
+	case req.Metalink != nil:
+		content, ext = req.Metalink, ".metalink"
+	default:
+		return nil
+	}
+
+	p := filepath.Join(s.fileDir, record.Id.String()+ext)
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := os.MkdirAll(s.fileDir, 0700); err != nil {
+			return err
+		}
+
+		// written to a temporary file first so that a crash can't leave a truncated file behind
+		if err := ioutil.WriteFile(p+".tmp", content, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(p+".tmp", p); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	req.Torrent, req.Metalink = nil, nil
+	record.RequestFile = p
+	return nil
+}
+
+func (s *boltTaskStore) Put(record TaskRecord) error {
+	if err := s.putRequestFile(&record); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).Put(record.Id[:], data)
+	})
+}
+
+func (s *boltTaskStore) Get(id uuid.UUID) (record TaskRecord, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(taskBucket).Get(id[:])
+		if data == nil {
+			return ErrTaskNotFound
+		}
+
+		return json.Unmarshal(data, &record)
+	})
+
+	return
+}
+
+func (s *boltTaskStore) Delete(id uuid.UUID) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).Delete(id[:])
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, ext := range requestFileExts {
+		if err := os.Remove(filepath.Join(s.fileDir, id.String()+ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *boltTaskStore) All() (records []TaskRecord, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).ForEach(func(_, data []byte) error {
+			var record TaskRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+
+			records = append(records, record)
+			return nil
+		})
+	})
+
+	return
+}
+
+func (s *boltTaskStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,92 @@
+package arias
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTaskStore(t *testing.T, store TaskStore) {
+	id := uuid.New()
+	record := TaskRecord{
+		Id:      id,
+		Request: DownloadRequest{Url: "http://example.org/file", Bucket: "bucket"},
+		GIDs:    []string{"2089b05ecca3d829"},
+		Status:  TaskStatus{Id: id.String(), Running: true, State: "downloading"},
+	}
+
+	_, err := store.Get(id)
+	assert.Equal(t, ErrTaskNotFound, err)
+
+	assert.NoError(t, store.Put(record))
+
+	stored, err := store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, record, stored)
+
+	records, err := store.All()
+	assert.NoError(t, err)
+	assert.Equal(t, []TaskRecord{record}, records)
+
+	assert.NoError(t, store.Delete(id))
+	_, err = store.Get(id)
+	assert.Equal(t, ErrTaskNotFound, err)
+
+	assert.NoError(t, store.Close())
+}
+
+func TestMemoryTaskStore(t *testing.T) {
+	testTaskStore(t, NewMemoryTaskStore())
+}
+
+func TestBoltTaskStore(t *testing.T) {
+	store, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+
+	testTaskStore(t, store)
+}
+
+func TestBoltTaskStoreRequestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	store, err := NewBoltTaskStore(path)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	id := uuid.New()
+	torrent := []byte("d8:announce0:e")
+	record := TaskRecord{Id: id, Request: DownloadRequest{Torrent: torrent, Bucket: "anime"}}
+	require.NoError(t, store.Put(record))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	assert.Nil(t, stored.Request.Torrent)
+	assert.Equal(t, filepath.Join(path+".files", id.String()+".torrent"), stored.RequestFile)
+
+	// The reference survives saving the record again
+	require.NoError(t, store.Put(stored))
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+
+	var req DownloadRequest
+	require.NoError(t, readRequestFile(&req, stored.RequestFile))
+	assert.Equal(t, torrent, req.Torrent)
+	assert.Nil(t, req.Metalink)
+
+	require.NoError(t, store.Delete(id))
+	_, err = os.Stat(stored.RequestFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTaskRecordLegacyGID(t *testing.T) {
+	var record TaskRecord
+	require.NoError(t, json.Unmarshal([]byte(`{"gid": "2089b05ecca3d829", "request": {"url": "https://example.org/ep1.mkv"}}`), &record))
+	assert.Equal(t, []string{"2089b05ecca3d829"}, record.GIDs)
+	assert.Equal(t, "https://example.org/ep1.mkv", record.Request.Url)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"gids": ["2089b05ecca3d829", "d2703803b52216d1"]}`), &record))
+	assert.Equal(t, []string{"2089b05ecca3d829", "d2703803b52216d1"}, record.GIDs)
+}